@@ -1,6 +1,8 @@
 package broker
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
 	"net"
 	"sync"
@@ -11,9 +13,11 @@ import (
 )
 
 type AsyncBroker struct {
-	Timeout  time.Duration
-	QueueLen int
-	Addr     string
+	Timeout         time.Duration
+	QueueLen        int
+	Addr            string
+	MaxRequestSize  int
+	MaxResponseSize int
 
 	mu sync.Mutex
 	br *broker
@@ -21,9 +25,11 @@ type AsyncBroker struct {
 
 func NewAsyncBroker(addr string) *AsyncBroker {
 	b := &AsyncBroker{
-		Addr:     addr,
-		Timeout:  30 * time.Second,
-		QueueLen: 1000,
+		Addr:            addr,
+		Timeout:         30 * time.Second,
+		QueueLen:        1000,
+		MaxRequestSize:  DefaultMaxRequestSize,
+		MaxResponseSize: DefaultMaxResponseSize,
 	}
 	return b
 }
@@ -67,9 +73,12 @@ func (b *AsyncBroker) Close() {
 }
 
 type broker struct {
-	timeout time.Duration
-	conn    net.Conn
-	cid     int32
+	timeout         time.Duration
+	maxRequestSize  int
+	maxResponseSize int
+	conn            net.Conn
+	r               *bufio.Reader
+	cid             int32
 
 	mu       sync.Mutex
 	recvChan chan *brokerJob
@@ -87,11 +96,14 @@ func (b *AsyncBroker) newBroker() (*broker, error) {
 		return nil, err
 	}
 	br := &broker{
-		timeout:  b.Timeout,
-		conn:     conn,
-		recvChan: make(chan *brokerJob, b.QueueLen),
+		timeout:         b.Timeout,
+		maxRequestSize:  b.MaxRequestSize,
+		maxResponseSize: b.MaxResponseSize,
+		conn:            conn,
+		r:               bufio.NewReader(conn),
+		recvChan:        make(chan *brokerJob, b.QueueLen),
 	}
-	go br.receiveLoop()
+	go br.receiveLoop(br.recvChan)
 	return br, nil
 }
 
@@ -116,10 +128,17 @@ func (b *broker) send(job *brokerJob) error {
 		return errChannelAlreadyClosed
 	}
 	job.req.SetID(atomic.AddInt32(&b.cid, 1))
+	var buf bytes.Buffer
+	if err := job.req.Send(&buf); err != nil {
+		return err
+	}
+	if err := checkRequestSize(buf.Len(), b.maxRequestSize); err != nil {
+		return err
+	}
 	if err := b.conn.SetWriteDeadline(time.Now().Add(b.timeout)); err != nil {
 		return err
 	}
-	if err := job.req.Send(b.conn); err != nil {
+	if _, err := b.conn.Write(buf.Bytes()); err != nil {
 		return err
 	}
 	if job.requireAck() {
@@ -142,13 +161,17 @@ var (
 	errChannelAlreadyClosed  = errors.New("channel already closed")
 )
 
-func (b *broker) receiveLoop() {
-	for job := range b.recvChan {
+func (b *broker) receiveLoop(recvChan chan *brokerJob) {
+	for job := range recvChan {
 		if err := b.conn.SetReadDeadline(time.Now().Add(b.timeout)); err != nil {
 			job.errChan <- err
 			continue
 		}
-		if err := job.resp.Receive(b.conn); err != nil {
+		if err := checkResponseSize(b.r, b.maxResponseSize); err != nil {
+			job.errChan <- err
+			continue
+		}
+		if err := job.resp.Receive(b.r); err != nil {
 			job.errChan <- err
 			continue
 		}
@@ -161,6 +184,7 @@ func (b *broker) receiveLoop() {
 	// no need to closeConn here because when recvChan closed, the receiveLoop will do it.
 	b.conn.Close()
 	b.conn = nil
+	b.r = nil
 }
 
 func (j *brokerJob) requireAck() bool { return j.resp != nil }
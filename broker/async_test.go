@@ -0,0 +1,72 @@
+package broker
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+type rawRequest struct {
+	id  int32
+	buf []byte
+}
+
+func (r *rawRequest) Send(w io.Writer) error { _, err := w.Write(r.buf); return err }
+func (r *rawRequest) ID() int32              { return r.id }
+func (r *rawRequest) SetID(id int32)         { r.id = id }
+
+type rawResponse struct{}
+
+func (r *rawResponse) Receive(rd io.Reader) error { return nil }
+func (r *rawResponse) ID() int32                  { return 1 }
+
+func TestResponseFromHTTPServer(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Read(make([]byte, 64))
+		conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+	}()
+	b := NewAsyncBroker(ln.Addr().String())
+	defer b.Close()
+	err = b.Do(&rawRequest{buf: make([]byte, 8)}, &rawResponse{})
+	sizeErr, ok := err.(*SizeError)
+	if !ok {
+		t.Fatalf("expect SizeError but got %v", err)
+	}
+	if !sizeErr.Response || sizeErr.LikelyCause() != "the endpoint looks like an HTTP server rather than a Kafka broker" {
+		t.Fatalf("unexpected error %v", sizeErr)
+	}
+}
+
+func TestRequestTooLarge(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}()
+	b := NewAsyncBroker(ln.Addr().String())
+	b.MaxRequestSize = 4
+	defer b.Close()
+	err = b.Do(&rawRequest{buf: make([]byte, 8)}, &rawResponse{})
+	if sizeErr, ok := err.(*SizeError); !ok || sizeErr.Response || sizeErr.Size != 8 {
+		t.Fatalf("expect request SizeError but got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package broker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	DefaultMaxRequestSize  = 100 * 1024 * 1024
+	DefaultMaxResponseSize = 100 * 1024 * 1024
+)
+
+// SizeError is returned when the size of a request or the size prefix of a
+// response exceeds the configured limit. An oversized response usually means
+// the address does not point to a plaintext Kafka listener.
+type SizeError struct {
+	Size     int64
+	Max      int
+	Response bool
+	Header   []byte // the first bytes received, only set for responses
+}
+
+func (e *SizeError) Error() string {
+	if !e.Response {
+		return fmt.Sprintf("broker: request size %d exceeds limit %d", e.Size, e.Max)
+	}
+	return fmt.Sprintf("broker: response size %d exceeds limit %d, %s", e.Size, e.Max, e.LikelyCause())
+}
+
+// LikelyCause guesses why the response size is invalid from the bytes that
+// were read in place of the size prefix.
+func (e *SizeError) LikelyCause() string {
+	switch {
+	case !e.Response:
+		return "the request is too large"
+	case bytes.HasPrefix(e.Header, []byte("HTTP")):
+		return "the endpoint looks like an HTTP server rather than a Kafka broker"
+	case len(e.Header) >= 2 && (e.Header[0] == 0x15 || e.Header[0] == 0x16) && e.Header[1] == 0x03:
+		return "the endpoint expects TLS (TLS mismatch)"
+	case e.Size < 0:
+		return "the endpoint is probably not a Kafka broker"
+	}
+	return "the endpoint is probably not a Kafka broker or the limit is too small"
+}
+
+func checkRequestSize(size, max int) error {
+	if max > 0 && size > max {
+		return &SizeError{Size: int64(size), Max: max}
+	}
+	return nil
+}
+
+// checkResponseSize peeks the size prefix of the next response without
+// consuming it.
+func checkResponseSize(r *bufio.Reader, max int) error {
+	header, err := r.Peek(4)
+	if err != nil {
+		return err
+	}
+	size := int64(int32(binary.BigEndian.Uint32(header)))
+	if size < 0 || (max > 0 && size > int64(max)) {
+		return &SizeError{
+			Size:     size,
+			Max:      max,
+			Response: true,
+			Header:   append([]byte(nil), header...),
+		}
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package cluster
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 
 	"h12.io/kpax/model"
@@ -12,6 +13,7 @@ import (
 var (
 	ErrLeaderNotFound = errors.New("leader not found")
 	ErrCoordNotFound  = errors.New("coordinator not found")
+	ErrCtrlNotFound   = errors.New("controller not found")
 	ErrNoBrokerFound  = errors.New("no broker found")
 )
 
@@ -19,22 +21,34 @@ type (
 	C struct {
 		topics *topicPartitions
 		pool   *brokerPool
+		flight flightGroup
+		subs   subscribers
+		stale  map[string]bool // topics seeded from a snapshot
+		quit   chan struct{}
+		done   chan struct{}
 		mu     sync.Mutex
 	}
 	NewBrokerFunc func(addr string) model.Broker
 )
 
-func New(newBroker NewBrokerFunc, brokers []string) model.Cluster {
+func New(newBroker NewBrokerFunc, brokers []string) *C {
 	c := &C{
 		topics: newTopicPartitions(),
 		pool:   newBrokerPool(newBroker),
 	}
 	for _, addr := range brokers {
-		c.pool.AddAddr(addr)
+		c.pool.AddBootstrap(addr)
 	}
 	return c
 }
 
+// Close stops the background refresher if any and closes all broker
+// connections.
+func (c *C) Close() {
+	c.stopRefresh()
+	c.pool.Close()
+}
+
 func (c *C) Coordinator(group string) (model.Broker, error) {
 	if coord, err := c.pool.GetCoordinator(group); err == nil {
 		return coord, nil
@@ -47,6 +61,41 @@ func (c *C) Coordinator(group string) (model.Broker, error) {
 
 func (c *C) CoordinatorIsDown(group string) {
 	c.pool.DeleteCoordinator(group)
+	c.revalidate()
+}
+
+// TransactionCoordinator returns the coordinator of a transactional producer.
+// It requires Kafka 0.11 or later.
+func (c *C) TransactionCoordinator(transactionalID string) (model.Broker, error) {
+	if coord, err := c.pool.GetTxnCoordinator(transactionalID); err == nil {
+		return coord, nil
+	}
+	if err := c.updateTxnCoordinator(transactionalID); err != nil {
+		return nil, err
+	}
+	return c.pool.GetTxnCoordinator(transactionalID)
+}
+
+func (c *C) TransactionCoordinatorIsDown(transactionalID string) {
+	c.pool.DeleteTxnCoordinator(transactionalID)
+	c.revalidate()
+}
+
+// Controller returns the controller broker, to which admin requests must be
+// sent. It requires Kafka 0.10 or later.
+func (c *C) Controller() (model.Broker, error) {
+	if ctrl, err := c.pool.GetController(); err == nil {
+		return ctrl, nil
+	}
+	if err := c.updateController(); err != nil {
+		return nil, err
+	}
+	return c.pool.GetController()
+}
+
+func (c *C) ControllerIsDown() {
+	c.pool.DeleteController()
+	c.revalidate()
 }
 
 func (c *C) Leader(topic string, partition int32) (model.Broker, error) {
@@ -54,6 +103,7 @@ func (c *C) Leader(topic string, partition int32) (model.Broker, error) {
 		return leader, nil
 	}
 	if err := c.updateFromTopicMetadata(topic); err != nil {
+		c.revalidate()
 		return nil, err
 	}
 	return c.pool.GetLeader(topic, partition)
@@ -61,6 +111,7 @@ func (c *C) Leader(topic string, partition int32) (model.Broker, error) {
 
 func (c *C) LeaderIsDown(topic string, partition int32) {
 	c.pool.DeleteLeader(topic, partition)
+	c.revalidate()
 }
 
 func (c *C) Partitions(topic string) ([]int32, error) {
@@ -69,6 +120,7 @@ func (c *C) Partitions(topic string) ([]int32, error) {
 		return partitions, nil
 	}
 	if err := c.updateFromTopicMetadata(topic); err != nil {
+		c.revalidate()
 		return nil, err
 	}
 	partitions = c.topics.getPartitions(topic)
@@ -78,57 +130,234 @@ func (c *C) Partitions(topic string) ([]int32, error) {
 	return nil, fmt.Errorf("topic %s not found", topic)
 }
 
-func (c *C) updateCoordinator(group string) error {
-	brokers, err := c.pool.Brokers()
+func (c *C) PartitionInfos(topic string) ([]model.PartitionInfo, error) {
+	infos := c.topics.getInfos(topic)
+	if len(infos) > 0 {
+		return infos, nil
+	}
+	if err := c.updateFromTopicMetadata(topic); err != nil {
+		c.revalidate()
+		return nil, err
+	}
+	infos = c.topics.getInfos(topic)
+	if len(infos) > 0 {
+		return infos, nil
+	}
+	return nil, fmt.Errorf("topic %s not found", topic)
+}
+
+// Topics fetches the names of all topics in the cluster and caches their
+// metadata.
+func (c *C) Topics() ([]string, error) {
+	m, err := c.updateFromClusterMetadata()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	var merr MultiError
-	for _, broker := range brokers {
+	topics := make([]string, 0, len(m.TopicMetadatas))
+	for i := range m.TopicMetadatas {
+		if t := &m.TopicMetadatas[i]; !t.HasError() {
+			topics = append(topics, t.TopicName)
+		}
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+// Brokers fetches all live brokers in the cluster.
+func (c *C) Brokers() ([]model.BrokerInfo, error) {
+	m, err := c.updateFromClusterMetadata()
+	if err != nil {
+		return nil, err
+	}
+	brokers := make([]model.BrokerInfo, len(m.Brokers))
+	for i := range m.Brokers {
+		brokers[i] = model.BrokerInfo{ID: m.Brokers[i].NodeID, Addr: m.Brokers[i].Addr()}
+	}
+	sort.Sort(brokersByID(brokers))
+	return brokers, nil
+}
+
+type brokersByID []model.BrokerInfo
+
+func (s brokersByID) Len() int           { return len(s) }
+func (s brokersByID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s brokersByID) Less(i, j int) bool { return s[i].ID < s[j].ID }
+
+func (c *C) updateCoordinator(group string) error {
+	_, err := c.flight.Do("group:"+group, func() (interface{}, error) {
+		return nil, c.doUpdateCoordinator(group)
+	})
+	return err
+}
+
+func (c *C) doUpdateCoordinator(group string) error {
+	return c.eachBroker(func(broker model.Broker) error {
 		coord, err := proto.GroupCoordinator(group).Fetch(broker)
 		if err != nil {
-			merr.Add(err)
-			continue
+			return err
 		}
-		c.pool.SetCoordinator(group, coord.NodeID, coord.Addr())
+		c.subs.emit(c.pool.SetCoordinator(group, coord.NodeID, coord.Addr())...)
 		return nil
-	}
-	return merr
+	})
+}
+
+func (c *C) updateTxnCoordinator(transactionalID string) error {
+	_, err := c.flight.Do("txn:"+transactionalID, func() (interface{}, error) {
+		return nil, c.doUpdateTxnCoordinator(transactionalID)
+	})
+	return err
+}
+
+func (c *C) doUpdateTxnCoordinator(transactionalID string) error {
+	return c.eachBroker(func(broker model.Broker) error {
+		coord, err := proto.TransactionCoordinator(transactionalID).Fetch(broker)
+		if err != nil {
+			return err
+		}
+		c.pool.SetTxnCoordinator(transactionalID, coord.NodeID, coord.Addr())
+		return nil
+	})
+}
+
+func (c *C) updateController() error {
+	_, err := c.flight.Do("controller", func() (interface{}, error) {
+		return nil, c.doUpdateController()
+	})
+	return err
+}
+
+func (c *C) doUpdateController() error {
+	return c.eachBroker(func(broker model.Broker) error {
+		m, err := proto.ControllerMetadata{}.Fetch(broker)
+		if err != nil {
+			return err
+		}
+		brokers := make([]proto.Broker, len(m.BrokerV1s))
+		for i, b := range m.BrokerV1s {
+			brokers[i] = proto.Broker{NodeID: b.NodeID, Host: b.Host, Port: b.Port}
+		}
+		c.addBrokers(brokers)
+		return c.pool.SetController(m.ControllerID)
+	})
 }
 
 func (c *C) updateFromTopicMetadata(topic string) error {
-	brokers, err := c.pool.Brokers()
-	if err != nil {
-		return err
-	}
-	var merr MultiError
-	for _, broker := range brokers {
+	_, err := c.flight.Do("topic:"+topic, func() (interface{}, error) {
+		return nil, c.doUpdateFromTopicMetadata(topic)
+	})
+	return err
+}
+
+func (c *C) doUpdateFromTopicMetadata(topic string) error {
+	return c.eachBroker(func(broker model.Broker) error {
 		// no retry, fail fast
 		m, err := proto.Metadata(topic).Fetch(broker)
 		if err != nil {
-			merr.Add(err)
-			continue
-		}
-		for i := range m.Brokers {
-			b := &m.Brokers[i]
-			c.pool.Add(b.NodeID, b.Addr())
+			return err
 		}
+		c.addBrokers(m.Brokers)
 		for i := range m.TopicMetadatas {
 			t := &m.TopicMetadatas[i]
 			if t.TopicName == topic {
-				partitions := make([]int32, len(t.PartitionMetadatas))
-				for i := range t.PartitionMetadatas {
-					partition := &t.PartitionMetadatas[i]
-					partitions[i] = partition.PartitionID
-					if err := c.pool.SetLeader(topic, partition.PartitionID, partition.Leader); err != nil {
-						merr.Add(err)
-						continue
-					}
-				}
-				c.topics.addPartitions(topic, partitions)
+				c.addTopic(t)
 				return nil
 			}
 		}
+		return proto.ErrUnknownTopicOrPartition
+	})
+}
+
+func (c *C) updateFromClusterMetadata() (*proto.TopicMetadataResponse, error) {
+	m, err := c.flight.Do("cluster", func() (interface{}, error) {
+		return c.doUpdateFromClusterMetadata()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m.(*proto.TopicMetadataResponse), nil
+}
+
+func (c *C) doUpdateFromClusterMetadata() (*proto.TopicMetadataResponse, error) {
+	var m *proto.TopicMetadataResponse
+	err := c.eachBroker(func(broker model.Broker) error {
+		var err error
+		m, err = proto.ClusterMetadata(nil).Fetch(broker)
+		if err != nil {
+			return err
+		}
+		c.addBrokers(m.Brokers)
+		for i := range m.TopicMetadatas {
+			if t := &m.TopicMetadatas[i]; !t.HasError() {
+				c.addTopic(t)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// eachBroker calls fn with each known broker until one succeeds. When none of
+// them is reachable, the bootstrap addresses are resolved again and tried as
+// well.
+func (c *C) eachBroker(fn func(model.Broker) error) error {
+	var merr MultiError
+	reachable := false
+	brokers, err := c.pool.Brokers()
+	if err != nil {
+		merr.Add(err)
+	}
+	for _, broker := range brokers {
+		if err := fn(broker); err != nil {
+			if _, ok := err.(proto.ErrorCode); ok {
+				reachable = true
+			}
+			merr.Add(err)
+			continue
+		}
+		return nil
+	}
+	if reachable {
+		return merr
+	}
+	for _, broker := range c.pool.Bootstrap() {
+		if err := fn(broker); err != nil {
+			merr.Add(err)
+			continue
+		}
+		return nil
 	}
 	return merr
 }
+
+// addBrokers reconciles the pool with the brokers advertised in a metadata
+// response, which always contains all live brokers of the cluster.
+func (c *C) addBrokers(brokers []proto.Broker) {
+	if len(brokers) == 0 {
+		return
+	}
+	idAddr := make(map[int32]string, len(brokers))
+	for i := range brokers {
+		b := &brokers[i]
+		idAddr[b.NodeID] = b.Addr()
+	}
+	c.subs.emit(c.pool.Reconcile(idAddr)...)
+}
+
+func (c *C) addTopic(t *proto.TopicMetadata) {
+	infos := make([]model.PartitionInfo, len(t.PartitionMetadatas))
+	for i := range t.PartitionMetadatas {
+		partition := &t.PartitionMetadatas[i]
+		infos[i] = model.PartitionInfo{
+			ID:       partition.PartitionID,
+			Leader:   partition.Leader,
+			Replicas: partition.Replicas,
+			ISR:      partition.ISR,
+		}
+		c.pool.SetLeader(t.TopicName, partition.PartitionID, partition.Leader)
+	}
+	old := c.topics.addPartitions(t.TopicName, infos)
+	c.subs.emit(topicEvents(t.TopicName, old, infos, c.pool.AddrOf)...)
+}
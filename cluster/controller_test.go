@@ -0,0 +1,41 @@
+package cluster
+
+import "testing"
+
+func TestController(t *testing.T) {
+	t.Parallel()
+	k := newFakeKafka(3)
+	k.controller = 2
+	c := New(k.newBroker, k.addrs()[:1])
+	defer c.Close()
+	ctrl, err := c.Controller()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := ctrl.(*fakeBroker).addr; addr != fakeAddr(9002) {
+		t.Fatalf("expect controller %s but got %s", fakeAddr(9002), addr)
+	}
+
+	k.mu.Lock()
+	k.controller = 1
+	k.mu.Unlock()
+	if ctrl, _ := c.Controller(); ctrl.(*fakeBroker).addr != fakeAddr(9002) {
+		t.Fatal("controller should be cached")
+	}
+	c.ControllerIsDown()
+	ctrl, err = c.Controller()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := ctrl.(*fakeBroker).addr; addr != fakeAddr(9001) {
+		t.Fatalf("expect controller %s but got %s", fakeAddr(9001), addr)
+	}
+
+	k.mu.Lock()
+	k.controller = -1
+	k.mu.Unlock()
+	c.ControllerIsDown()
+	if _, err := c.Controller(); err == nil {
+		t.Fatal("expect error when there is no controller")
+	}
+}
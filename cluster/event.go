@@ -0,0 +1,141 @@
+package cluster
+
+import (
+	"fmt"
+	"sync"
+
+	"h12.io/kpax/log"
+	"h12.io/kpax/model"
+)
+
+type EventType int
+
+const (
+	PartitionsAdded EventType = iota
+	LeaderChanged
+	BrokerAdded
+	BrokerRemoved
+	CoordinatorMoved
+)
+
+func (t EventType) String() string {
+	switch t {
+	case PartitionsAdded:
+		return "partitions added"
+	case LeaderChanged:
+		return "leader changed"
+	case BrokerAdded:
+		return "broker added"
+	case BrokerRemoved:
+		return "broker removed"
+	case CoordinatorMoved:
+		return "coordinator moved"
+	}
+	return fmt.Sprintf("unknown event %d", int(t))
+}
+
+// Event describes a difference observed by a metadata refresh. Only the
+// fields relevant to the event type are set.
+type Event struct {
+	Type       EventType
+	Topic      string  // PartitionsAdded, LeaderChanged
+	Partitions []int32 // PartitionsAdded
+	Partition  int32   // LeaderChanged
+	Group      string  // CoordinatorMoved
+	Broker     model.BrokerInfo
+	OldBroker  model.BrokerInfo // LeaderChanged, CoordinatorMoved
+}
+
+func (e Event) String() string {
+	switch e.Type {
+	case PartitionsAdded:
+		return fmt.Sprintf("%v: %s %v", e.Type, e.Topic, e.Partitions)
+	case LeaderChanged:
+		return fmt.Sprintf("%v: %s/%d %d -> %d", e.Type, e.Topic, e.Partition, e.OldBroker.ID, e.Broker.ID)
+	case CoordinatorMoved:
+		return fmt.Sprintf("%v: %s %d -> %d", e.Type, e.Group, e.OldBroker.ID, e.Broker.ID)
+	}
+	return fmt.Sprintf("%v: %d %s", e.Type, e.Broker.ID, e.Broker.Addr)
+}
+
+const eventQueueLen = 100
+
+type subscribers struct {
+	m  map[chan Event]struct{}
+	mu sync.Mutex
+}
+
+// Subscribe returns a channel of metadata change events. Events are dropped
+// if the channel is full, so the receiver should keep up. cancel must be
+// called to release the subscription and close the channel.
+func (c *C) Subscribe() (events <-chan Event, cancel func()) {
+	ch := make(chan Event, eventQueueLen)
+	s := &c.subs
+	s.mu.Lock()
+	if s.m == nil {
+		s.m = make(map[chan Event]struct{})
+	}
+	s.m[ch] = struct{}{}
+	s.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			delete(s.m, ch)
+			s.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (s *subscribers) emit(events ...Event) {
+	if len(events) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range events {
+		for ch := range s.m {
+			select {
+			case ch <- e:
+			default:
+				log.Warnf("cluster event dropped: %v", e)
+			}
+		}
+	}
+}
+
+// topicEvents compares the cached partitions of a topic with the new ones.
+// A topic seen for the first time has no events.
+func topicEvents(topic string, oldInfos, newInfos []model.PartitionInfo, addrOf func(int32) string) []Event {
+	if len(oldInfos) == 0 {
+		return nil
+	}
+	old := make(map[int32]*model.PartitionInfo, len(oldInfos))
+	for i := range oldInfos {
+		old[oldInfos[i].ID] = &oldInfos[i]
+	}
+	var events []Event
+	var added []int32
+	for i := range newInfos {
+		info := &newInfos[i]
+		oldInfo, ok := old[info.ID]
+		if !ok {
+			added = append(added, info.ID)
+			continue
+		}
+		if oldInfo.Leader != info.Leader {
+			events = append(events, Event{
+				Type:      LeaderChanged,
+				Topic:     topic,
+				Partition: info.ID,
+				Broker:    model.BrokerInfo{ID: info.Leader, Addr: addrOf(info.Leader)},
+				OldBroker: model.BrokerInfo{ID: oldInfo.Leader, Addr: addrOf(oldInfo.Leader)},
+			})
+		}
+	}
+	if len(added) > 0 {
+		events = append(events, Event{Type: PartitionsAdded, Topic: topic, Partitions: added})
+	}
+	return events
+}
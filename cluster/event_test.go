@@ -0,0 +1,59 @@
+package cluster
+
+import (
+	"testing"
+
+	"h12.io/kpax/proto"
+)
+
+func TestEvents(t *testing.T) {
+	t.Parallel()
+	k := newFakeKafka(2)
+	k.setTopic("test", 0)
+	k.coordinators["group"] = 0
+	c := New(k.newBroker, k.addrs())
+	defer c.Close()
+	events, cancel := c.Subscribe()
+	defer cancel()
+	if _, err := c.Partitions("test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Coordinator("group"); err != nil {
+		t.Fatal(err)
+	}
+
+	k.setBrokers(append(k.brokers, proto.Broker{NodeID: 2, Host: "fake", Port: 9002})...)
+	k.setTopic("test", 1, 2)
+	k.mu.Lock()
+	k.coordinators["group"] = 1
+	k.mu.Unlock()
+	if _, err := c.Topics(); err != nil {
+		t.Fatal(err)
+	}
+	c.CoordinatorIsDown("group")
+	if _, err := c.Coordinator("group"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"broker added: 2 " + fakeAddr(9002),
+		"leader changed: test/0 0 -> 1",
+		"partitions added: test [1]",
+		"coordinator moved: group 0 -> 1",
+	}
+	for _, s := range expected {
+		select {
+		case e := <-events:
+			if e.String() != s {
+				t.Fatalf("expect event %q but got %q", s, e.String())
+			}
+		default:
+			t.Fatalf("expect event %q but got nothing", s)
+		}
+	}
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event %v", e)
+	default:
+	}
+}
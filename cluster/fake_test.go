@@ -0,0 +1,172 @@
+package cluster
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+
+	"h12.io/kpax/model"
+	"h12.io/kpax/proto"
+)
+
+var errBrokerDown = errors.New("fake broker is down")
+
+// fakeKafka serves metadata and coordinator requests from memory
+type fakeKafka struct {
+	brokers       []proto.Broker
+	topics        map[string][]proto.PartitionMetadata
+	coordinators  map[string]int32
+	controller    int32
+	down          map[string]bool
+	closed        map[string]bool
+	metadataCalls int
+	gate          chan struct{} // blocks requests until closed if not nil
+	mu            sync.Mutex
+}
+
+func newFakeKafka(brokerCount int) *fakeKafka {
+	k := &fakeKafka{
+		topics:       make(map[string][]proto.PartitionMetadata),
+		coordinators: make(map[string]int32),
+		down:         make(map[string]bool),
+		closed:       make(map[string]bool),
+	}
+	for i := 0; i < brokerCount; i++ {
+		k.brokers = append(k.brokers, proto.Broker{NodeID: int32(i), Host: "fake", Port: int32(9000 + i)})
+	}
+	return k
+}
+
+func (k *fakeKafka) addrs() []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	addrs := make([]string, len(k.brokers))
+	for i := range k.brokers {
+		addrs[i] = k.brokers[i].Addr()
+	}
+	return addrs
+}
+
+// setTopic sets the leaders of the topic, one partition per leader
+func (k *fakeKafka) setTopic(topic string, leaders ...int32) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	partitions := make([]proto.PartitionMetadata, len(leaders))
+	for i, leader := range leaders {
+		partitions[i] = proto.PartitionMetadata{
+			PartitionID: int32(i),
+			Leader:      leader,
+			Replicas:    []int32{leader},
+			ISR:         []int32{leader},
+		}
+	}
+	k.topics[topic] = partitions
+}
+
+func (k *fakeKafka) setPartitions(topic string, partitions ...proto.PartitionMetadata) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.topics[topic] = partitions
+}
+
+func (k *fakeKafka) setBrokers(brokers ...proto.Broker) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.brokers = brokers
+}
+
+func (k *fakeKafka) isClosed(addr string) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.closed[addr]
+}
+
+func (k *fakeKafka) setDown(addr string, down bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.down[addr] = down
+}
+
+func (k *fakeKafka) calls() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.metadataCalls
+}
+
+func (k *fakeKafka) newBroker(addr string) model.Broker {
+	return &fakeBroker{addr: addr, k: k}
+}
+
+type fakeBroker struct {
+	addr string
+	k    *fakeKafka
+}
+
+func (b *fakeBroker) Do(req model.Request, resp model.Response) error {
+	k := b.k
+	k.mu.Lock()
+	gate := k.gate
+	k.mu.Unlock()
+	if gate != nil {
+		<-gate
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.down[b.addr] {
+		return errBrokerDown
+	}
+	switch r := req.(*proto.Request).RequestMessage.(type) {
+	case *proto.TopicMetadataRequest:
+		k.metadataCalls++
+		m := resp.(*proto.Response).ResponseMessage.(*proto.TopicMetadataResponse)
+		m.Brokers = append([]proto.Broker(nil), k.brokers...)
+		topics := []string(*r)
+		if len(topics) == 0 {
+			for topic := range k.topics {
+				topics = append(topics, topic)
+			}
+		}
+		for _, topic := range topics {
+			t := proto.TopicMetadata{TopicName: topic}
+			if partitions, ok := k.topics[topic]; ok {
+				t.PartitionMetadatas = append([]proto.PartitionMetadata(nil), partitions...)
+			} else {
+				t.ErrorCode = proto.ErrUnknownTopicOrPartition
+			}
+			m.TopicMetadatas = append(m.TopicMetadatas, t)
+		}
+	case *proto.GroupCoordinatorRequest:
+		m := resp.(*proto.Response).ResponseMessage.(*proto.GroupCoordinatorResponse)
+		id, ok := k.coordinators[string(*r)]
+		if !ok {
+			m.ErrorCode = proto.ErrGroupCoordinatorNotAvailableCode
+			return nil
+		}
+		for _, broker := range k.brokers {
+			if broker.NodeID == id {
+				m.Broker = broker
+			}
+		}
+	case *proto.TopicMetadataRequestV1:
+		m := resp.(*proto.Response).ResponseMessage.(*proto.TopicMetadataResponseV1)
+		for _, b := range k.brokers {
+			m.BrokerV1s = append(m.BrokerV1s, proto.BrokerV1{NodeID: b.NodeID, Host: b.Host, Port: b.Port})
+		}
+		m.ControllerID = k.controller
+	case *proto.ListGroupsRequest:
+	default:
+		return errors.New("fake broker: unsupported request")
+	}
+	return nil
+}
+
+func (b *fakeBroker) Close() {
+	b.k.mu.Lock()
+	defer b.k.mu.Unlock()
+	b.k.closed[b.addr] = true
+}
+
+func fakeAddr(port int) string {
+	return net.JoinHostPort("fake", strconv.Itoa(port))
+}
@@ -0,0 +1,37 @@
+package cluster
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestConcurrentLeaderLookup(t *testing.T) {
+	t.Parallel()
+	k := newFakeKafka(3)
+	k.setTopic("test", 0, 1, 2)
+	k.gate = make(chan struct{})
+	c := New(k.newBroker, k.addrs())
+	defer c.Close()
+
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(partition int32) {
+			defer wg.Done()
+			if _, err := c.Leader("test", partition); err != nil {
+				errs <- err
+			}
+		}(int32(i % 3))
+	}
+	close(k.gate)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if calls := k.calls(); calls != 1 {
+		t.Fatalf("expect 1 metadata request but got %d", calls)
+	}
+}
@@ -0,0 +1,173 @@
+package cluster
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"h12.io/kpax/model"
+	"h12.io/kpax/proto"
+)
+
+var errNotAdvertised = errors.New("broker is no longer advertised in the metadata")
+
+// HealthReport is the result of a health check of the whole cluster.
+type HealthReport struct {
+	Brokers                   []BrokerStatus
+	UnreachableBrokers        []BrokerStatus
+	OfflinePartitions         []PartitionStatus // leader is -1
+	UnderReplicatedPartitions []PartitionStatus // ISR is smaller than replicas
+	LeaderNotAvailable        []PartitionStatus // ErrLeaderNotAvailable returned
+}
+
+type BrokerStatus struct {
+	model.BrokerInfo
+	Err string `json:",omitempty"`
+}
+
+type PartitionStatus struct {
+	Topic string
+	model.PartitionInfo
+}
+
+// Healthy returns true if all brokers are reachable and all partitions are
+// online and fully replicated.
+func (r *HealthReport) Healthy() bool {
+	return len(r.UnreachableBrokers) == 0 &&
+		len(r.OfflinePartitions) == 0 &&
+		len(r.UnderReplicatedPartitions) == 0 &&
+		len(r.LeaderNotAvailable) == 0
+}
+
+// Health fetches the metadata of all topics and probes every known broker
+// of the cluster. A broker known before but no longer advertised by the
+// metadata is reported as unreachable.
+func (c *C) Health() (*HealthReport, error) {
+	// the refresh drops the brokers that left, so take them before it
+	known := c.pool.BrokerInfos()
+	m, err := c.updateFromClusterMetadata()
+	if err != nil {
+		return nil, err
+	}
+	advertised := make(map[int32]bool, len(m.Brokers))
+	var brokers []model.BrokerInfo
+	for i := range m.Brokers {
+		b := &m.Brokers[i]
+		advertised[b.NodeID] = true
+		brokers = append(brokers, model.BrokerInfo{ID: b.NodeID, Addr: b.Addr()})
+	}
+	var departed []model.BrokerInfo
+	for _, info := range known {
+		if !advertised[info.ID] {
+			departed = append(departed, info)
+		}
+	}
+	report := &HealthReport{
+		Brokers: make([]BrokerStatus, len(brokers)+len(departed)),
+	}
+	var wg sync.WaitGroup
+	wg.Add(len(report.Brokers))
+	for i, info := range append(brokers, departed...) {
+		go func(info model.BrokerInfo, advertised bool, status *BrokerStatus) {
+			defer wg.Done()
+			status.BrokerInfo = info
+			probe := c.probe
+			if !advertised {
+				probe = c.probeDeparted
+			}
+			if err := probe(info); err != nil {
+				status.Err = err.Error()
+			}
+		}(info, i < len(brokers), &report.Brokers[i])
+	}
+	wg.Wait()
+	sort.Sort(brokerStatusByID(report.Brokers))
+	for _, status := range report.Brokers {
+		if status.Err != "" {
+			report.UnreachableBrokers = append(report.UnreachableBrokers, status)
+		}
+	}
+
+	for i := range m.TopicMetadatas {
+		t := &m.TopicMetadatas[i]
+		if t.ErrorCode == proto.ErrLeaderNotAvailable {
+			report.LeaderNotAvailable = append(report.LeaderNotAvailable, PartitionStatus{
+				Topic:         t.TopicName,
+				PartitionInfo: model.PartitionInfo{ID: -1, Leader: -1},
+			})
+		}
+		for j := range t.PartitionMetadatas {
+			p := &t.PartitionMetadatas[j]
+			status := PartitionStatus{
+				Topic: t.TopicName,
+				PartitionInfo: model.PartitionInfo{
+					ID:       p.PartitionID,
+					Leader:   p.Leader,
+					Replicas: p.Replicas,
+					ISR:      p.ISR,
+				},
+			}
+			if p.ErrorCode == proto.ErrLeaderNotAvailable {
+				report.LeaderNotAvailable = append(report.LeaderNotAvailable, status)
+			}
+			if p.Leader == -1 {
+				report.OfflinePartitions = append(report.OfflinePartitions, status)
+			}
+			if len(p.ISR) < len(p.Replicas) {
+				report.UnderReplicatedPartitions = append(report.UnderReplicatedPartitions, status)
+			}
+		}
+	}
+	sort.Sort(partitionStatuses(report.LeaderNotAvailable))
+	sort.Sort(partitionStatuses(report.OfflinePartitions))
+	sort.Sort(partitionStatuses(report.UnderReplicatedPartitions))
+	return report, nil
+}
+
+func (c *C) probe(info model.BrokerInfo) error {
+	broker, err := c.pool.Get(info.ID)
+	if err != nil {
+		return err
+	}
+	return probe(broker)
+}
+
+// probeDeparted probes a broker dropped from the pool with a temporary
+// connection. It is unusable even if it answers, because the cluster no
+// longer advertises it.
+func (c *C) probeDeparted(info model.BrokerInfo) error {
+	broker := c.pool.newBroker(info.Addr)
+	defer broker.Close()
+	if err := probe(broker); err != nil {
+		return err
+	}
+	return errNotAdvertised
+}
+
+// probe sends a cheap request to the broker, an error code in the response
+// still means the broker is reachable.
+func probe(broker model.Broker) error {
+	if _, err := (proto.ListGroups{}).Fetch(broker); err != nil {
+		if _, ok := err.(proto.ErrorCode); !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+type brokerStatusByID []BrokerStatus
+
+func (s brokerStatusByID) Len() int           { return len(s) }
+func (s brokerStatusByID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s brokerStatusByID) Less(i, j int) bool { return s[i].ID < s[j].ID }
+
+type partitionStatuses []PartitionStatus
+
+func (s partitionStatuses) Len() int      { return len(s) }
+func (s partitionStatuses) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s partitionStatuses) Less(i, j int) bool {
+	if s[i].Topic != s[j].Topic {
+		return s[i].Topic < s[j].Topic
+	}
+	return s[i].ID < s[j].ID
+}
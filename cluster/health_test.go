@@ -0,0 +1,79 @@
+package cluster
+
+import (
+	"testing"
+
+	"h12.io/kpax/proto"
+)
+
+func TestHealth(t *testing.T) {
+	t.Parallel()
+	k := newFakeKafka(3)
+	k.setTopic("healthy", 0, 1)
+	k.setPartitions("degraded",
+		proto.PartitionMetadata{PartitionID: 0, Leader: 0, Replicas: []int32{0, 1}, ISR: []int32{0}},
+		proto.PartitionMetadata{PartitionID: 1, Leader: -1, Replicas: []int32{2}, ISR: []int32{}, ErrorCode: proto.ErrLeaderNotAvailable},
+	)
+	k.setDown(fakeAddr(9002), true)
+	c := New(k.newBroker, k.addrs()[:1])
+	defer c.Close()
+
+	report, err := c.Health()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Healthy() {
+		t.Fatal("expect unhealthy cluster")
+	}
+	if len(report.Brokers) != 3 {
+		t.Fatalf("expect 3 brokers but got %v", report.Brokers)
+	}
+	if s := report.UnreachableBrokers; len(s) != 1 || s[0].ID != 2 || s[0].Err == "" {
+		t.Fatalf("unexpected unreachable brokers %v", s)
+	}
+	if s := report.UnderReplicatedPartitions; len(s) != 2 || s[0].Topic != "degraded" || s[0].ID != 0 || s[1].ID != 1 {
+		t.Fatalf("unexpected under-replicated partitions %v", s)
+	}
+	if s := report.OfflinePartitions; len(s) != 1 || s[0].ID != 1 {
+		t.Fatalf("unexpected offline partitions %v", s)
+	}
+	if s := report.LeaderNotAvailable; len(s) != 1 || s[0].ID != 1 {
+		t.Fatalf("unexpected leader-not-available partitions %v", s)
+	}
+}
+
+func TestHealthDepartedBroker(t *testing.T) {
+	t.Parallel()
+	k := newFakeKafka(3)
+	k.setTopic("a", 0, 1, 2)
+	c := New(k.newBroker, k.addrs()[:1])
+	defer c.Close()
+	if _, err := c.Partitions("a"); err != nil {
+		t.Fatal(err)
+	}
+	k.setBrokers(k.brokers[:2]...)
+	k.setDown(fakeAddr(9002), true)
+	k.setTopic("a", 0, 1)
+
+	report, err := c.Health()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Brokers) != 3 {
+		t.Fatalf("expect 3 brokers but got %v", report.Brokers)
+	}
+	if s := report.UnreachableBrokers; len(s) != 1 || s[0].ID != 2 || s[0].Addr != fakeAddr(9002) || s[0].Err == "" {
+		t.Fatalf("unexpected unreachable brokers %v", s)
+	}
+
+	// a departed broker still answering is unreachable for the clients
+	k.setDown(fakeAddr(9002), false)
+	k.setBrokers(k.brokers[:1]...)
+	report, err = c.Health()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := report.UnreachableBrokers; len(s) != 1 || s[0].ID != 1 || s[0].Err != errNotAdvertised.Error() {
+		t.Fatalf("unexpected unreachable brokers %v", s)
+	}
+}
@@ -0,0 +1,49 @@
+package cluster
+
+import (
+	"reflect"
+	"testing"
+
+	"h12.io/kpax/model"
+)
+
+func TestTopicsAndBrokers(t *testing.T) {
+	t.Parallel()
+	k := newFakeKafka(3)
+	k.setTopic("b", 2, 1)
+	k.setTopic("a", 0)
+	c := New(k.newBroker, k.addrs()[:1])
+	defer c.Close()
+
+	topics, err := c.Topics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"a", "b"}; !reflect.DeepEqual(topics, expected) {
+		t.Fatalf("expect topics %v but got %v", expected, topics)
+	}
+
+	brokers, err := c.Brokers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(brokers) != 3 || brokers[2] != (model.BrokerInfo{ID: 2, Addr: fakeAddr(9002)}) {
+		t.Fatalf("unexpected brokers %v", brokers)
+	}
+
+	calls := k.calls()
+	infos, err := c.PartitionInfos("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.calls() != calls {
+		t.Fatal("partition infos should be cached by Topics")
+	}
+	expected := []model.PartitionInfo{
+		{ID: 0, Leader: 2, Replicas: []int32{2}, ISR: []int32{2}},
+		{ID: 1, Leader: 1, Replicas: []int32{1}, ISR: []int32{1}},
+	}
+	if !reflect.DeepEqual(infos, expected) {
+		t.Fatalf("expect %v but got %v", expected, infos)
+	}
+}
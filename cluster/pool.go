@@ -1,10 +1,12 @@
 package cluster
 
 import (
+	"net"
+	"sort"
 	"sync"
 
-	"h12.io/kpax/model"
 	"h12.io/kpax/log"
+	"h12.io/kpax/model"
 )
 
 type brokerPool struct {
@@ -12,7 +14,12 @@ type brokerPool struct {
 	idAddr               map[int32]string
 	topicPartitionLeader map[topicPartition]model.Broker
 	groupCoordinator     map[string]model.Broker
+	groupCoordinatorID   map[string]int32 // kept after the coordinator is down
+	txnCoordinator       map[string]model.Broker
+	controller           model.Broker
+	bootstrap            []string
 	newBroker            func(string) model.Broker
+	lookupHost           func(string) ([]string, error)
 	mu                   sync.Mutex
 }
 
@@ -27,23 +34,168 @@ func newBrokerPool(newBroker func(string) model.Broker) *brokerPool {
 		idAddr:               make(map[int32]string),
 		topicPartitionLeader: make(map[topicPartition]model.Broker),
 		groupCoordinator:     make(map[string]model.Broker),
+		groupCoordinatorID:   make(map[string]int32),
+		txnCoordinator:       make(map[string]model.Broker),
 		newBroker:            newBroker,
+		lookupHost:           net.LookupHost,
 	}
 }
 
-func (p *brokerPool) Brokers() (map[string]model.Broker, error) {
-	if len(p.addrBroker) > 0 {
-		return p.addrBroker, nil
+// Brokers returns a snapshot of all brokers in the pool.
+func (p *brokerPool) Brokers() ([]model.Broker, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.addrBroker) == 0 {
+		return nil, ErrNoBrokerFound
+	}
+	brokers := make([]model.Broker, 0, len(p.addrBroker))
+	for _, broker := range p.addrBroker {
+		brokers = append(brokers, broker)
+	}
+	return brokers, nil
+}
+
+func (p *brokerPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, broker := range p.addrBroker {
+		broker.Close()
 	}
-	return nil, ErrNoBrokerFound
 }
 
-func (p *brokerPool) AddAddr(addr string) model.Broker {
+// AddBootstrap adds a broker address used to discover the cluster.
+func (p *brokerPool) AddBootstrap(addr string) model.Broker {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.bootstrap = append(p.bootstrap, addr)
 	return p.addAddr(addr)
 }
 
+// Bootstrap resolves the bootstrap addresses again and returns the brokers
+// that are not in the pool yet. An address that cannot be resolved is used
+// as it is.
+func (p *brokerPool) Bootstrap() []model.Broker {
+	p.mu.Lock()
+	bootstrap := p.bootstrap
+	p.mu.Unlock()
+	var addrs []string
+	for _, addr := range bootstrap {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			addrs = append(addrs, addr)
+			continue
+		}
+		ips, err := p.lookupHost(host)
+		if err != nil {
+			log.Warnf("fail to resolve bootstrap address %s: %v", addr, err)
+			addrs = append(addrs, addr)
+			continue
+		}
+		for _, ip := range ips {
+			addrs = append(addrs, net.JoinHostPort(ip, port))
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var brokers []model.Broker
+	for _, addr := range addrs {
+		if _, ok := p.addrBroker[addr]; !ok {
+			brokers = append(brokers, p.addAddr(addr))
+		}
+	}
+	return brokers
+}
+
+// Reconcile makes the pool match the brokers advertised by the cluster:
+// moved broker IDs are updated, and brokers no longer advertised are closed
+// and dropped together with the leaders and coordinators cached for them.
+// The changes are returned as events unless the pool knew no broker IDs yet.
+func (p *brokerPool) Reconcile(idAddr map[int32]string) []Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	initial := len(p.idAddr) == 0
+	var events []Event
+	advertised := make(map[string]bool, len(idAddr))
+	for id, addr := range idAddr {
+		if oldAddr, ok := p.idAddr[id]; !ok {
+			events = append(events, Event{Type: BrokerAdded, Broker: model.BrokerInfo{ID: id, Addr: addr}})
+		} else if oldAddr != addr {
+			log.Infof("broker %d moved from %s to %s", id, oldAddr, addr)
+			events = append(events,
+				Event{Type: BrokerRemoved, Broker: model.BrokerInfo{ID: id, Addr: oldAddr}},
+				Event{Type: BrokerAdded, Broker: model.BrokerInfo{ID: id, Addr: addr}})
+		}
+		p.add(id, addr)
+		advertised[addr] = true
+	}
+	for id, addr := range p.idAddr {
+		if _, ok := idAddr[id]; !ok {
+			log.Infof("broker %d left the cluster", id)
+			events = append(events, Event{Type: BrokerRemoved, Broker: model.BrokerInfo{ID: id, Addr: addr}})
+			delete(p.idAddr, id)
+		}
+	}
+	for addr, broker := range p.addrBroker {
+		if advertised[addr] {
+			continue
+		}
+		delete(p.addrBroker, addr)
+		p.purge(broker)
+		broker.Close()
+	}
+	if initial {
+		return nil
+	}
+	return events
+}
+
+func (p *brokerPool) Get(brokerID int32) (model.Broker, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.find(brokerID)
+}
+
+func (p *brokerPool) BrokerInfos() []model.BrokerInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	brokers := make([]model.BrokerInfo, 0, len(p.idAddr))
+	for id, addr := range p.idAddr {
+		brokers = append(brokers, model.BrokerInfo{ID: id, Addr: addr})
+	}
+	sort.Sort(brokersByID(brokers))
+	return brokers
+}
+
+// AddrOf returns the address of a broker ID or an empty string if unknown.
+func (p *brokerPool) AddrOf(brokerID int32) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.idAddr[brokerID]
+}
+
+// purge deletes the leaders, coordinators and controller served by the
+// broker.
+func (p *brokerPool) purge(broker model.Broker) {
+	for tp, leader := range p.topicPartitionLeader {
+		if leader == broker {
+			delete(p.topicPartitionLeader, tp)
+		}
+	}
+	for group, coord := range p.groupCoordinator {
+		if coord == broker {
+			delete(p.groupCoordinator, group)
+		}
+	}
+	for id, coord := range p.txnCoordinator {
+		if coord == broker {
+			delete(p.txnCoordinator, id)
+		}
+	}
+	if p.controller == broker {
+		p.controller = nil
+	}
+}
+
 func (p *brokerPool) addAddr(addr string) model.Broker {
 	if broker, ok := p.addrBroker[addr]; ok {
 		return broker
@@ -69,6 +221,7 @@ func (p *brokerPool) SetLeader(topic string, partition int32, brokerID int32) er
 	defer p.mu.Unlock()
 	broker, err := p.find(brokerID)
 	if err != nil {
+		delete(p.topicPartitionLeader, topicPartition{topic, partition})
 		return err
 	}
 	p.topicPartitionLeader[topicPartition{topic, partition}] = broker
@@ -97,11 +250,24 @@ func (p *brokerPool) DeleteCoordinator(consumerGroup string) {
 	delete(p.groupCoordinator, consumerGroup)
 }
 
-func (p *brokerPool) SetCoordinator(consumerGroup string, brokerID int32, addr string) {
+// SetCoordinator returns a CoordinatorMoved event if the group had a
+// different coordinator before.
+func (p *brokerPool) SetCoordinator(consumerGroup string, brokerID int32, addr string) []Event {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	broker := p.add(brokerID, addr)
 	p.groupCoordinator[consumerGroup] = broker
+	oldID, ok := p.groupCoordinatorID[consumerGroup]
+	p.groupCoordinatorID[consumerGroup] = brokerID
+	if !ok || oldID == brokerID {
+		return nil
+	}
+	return []Event{{
+		Type:      CoordinatorMoved,
+		Group:     consumerGroup,
+		Broker:    model.BrokerInfo{ID: brokerID, Addr: addr},
+		OldBroker: model.BrokerInfo{ID: oldID, Addr: p.idAddr[oldID]},
+	}}
 }
 
 func (p *brokerPool) GetCoordinator(consumerGroup string) (model.Broker, error) {
@@ -114,6 +280,54 @@ func (p *brokerPool) GetCoordinator(consumerGroup string) (model.Broker, error)
 	return broker, nil
 }
 
+func (p *brokerPool) SetTxnCoordinator(transactionalID string, brokerID int32, addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.txnCoordinator[transactionalID] = p.add(brokerID, addr)
+}
+
+func (p *brokerPool) GetTxnCoordinator(transactionalID string) (model.Broker, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	broker, ok := p.txnCoordinator[transactionalID]
+	if !ok {
+		return nil, ErrCoordNotFound
+	}
+	return broker, nil
+}
+
+func (p *brokerPool) DeleteTxnCoordinator(transactionalID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.txnCoordinator, transactionalID)
+}
+
+func (p *brokerPool) SetController(brokerID int32) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	broker, err := p.find(brokerID)
+	if err != nil {
+		return ErrCtrlNotFound
+	}
+	p.controller = broker
+	return nil
+}
+
+func (p *brokerPool) GetController() (model.Broker, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.controller == nil {
+		return nil, ErrCtrlNotFound
+	}
+	return p.controller, nil
+}
+
+func (p *brokerPool) DeleteController() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.controller = nil
+}
+
 func (p *brokerPool) find(brokerID int32) (model.Broker, error) {
 	if addr, ok := p.idAddr[brokerID]; ok {
 		if broker, ok := p.addrBroker[addr]; ok {
@@ -125,24 +339,62 @@ func (p *brokerPool) find(brokerID int32) (model.Broker, error) {
 }
 
 type topicPartitions struct {
-	m  map[string][]int32
+	m  map[string]*topicMeta
 	mu sync.Mutex
 }
 
+type topicMeta struct {
+	partitions []int32
+	infos      []model.PartitionInfo
+}
+
 func newTopicPartitions() *topicPartitions {
 	return &topicPartitions{
-		m: make(map[string][]int32),
+		m: make(map[string]*topicMeta),
 	}
 }
 
 func (tp *topicPartitions) getPartitions(topic string) []int32 {
 	tp.mu.Lock()
 	defer tp.mu.Unlock()
-	return tp.m[topic]
+	if meta, ok := tp.m[topic]; ok {
+		return meta.partitions
+	}
+	return nil
+}
+
+func (tp *topicPartitions) getInfos(topic string) []model.PartitionInfo {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	if meta, ok := tp.m[topic]; ok {
+		return meta.infos
+	}
+	return nil
 }
 
-func (tp *topicPartitions) addPartitions(topic string, partitions []int32) {
+func (tp *topicPartitions) getTopics() []string {
 	tp.mu.Lock()
 	defer tp.mu.Unlock()
-	tp.m[topic] = partitions
+	topics := make([]string, 0, len(tp.m))
+	for topic := range tp.m {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// addPartitions replaces the partitions of the topic and returns the old
+// ones.
+func (tp *topicPartitions) addPartitions(topic string, infos []model.PartitionInfo) []model.PartitionInfo {
+	partitions := make([]int32, len(infos))
+	for i := range infos {
+		partitions[i] = infos[i].ID
+	}
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	var old []model.PartitionInfo
+	if meta, ok := tp.m[topic]; ok {
+		old = meta.infos
+	}
+	tp.m[topic] = &topicMeta{partitions: partitions, infos: infos}
+	return old
 }
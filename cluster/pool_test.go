@@ -0,0 +1,78 @@
+package cluster
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"h12.io/kpax/proto"
+)
+
+func poolAddrs(c *C) []string {
+	c.pool.mu.Lock()
+	defer c.pool.mu.Unlock()
+	var addrs []string
+	for addr := range c.pool.addrBroker {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func TestReconcileBrokers(t *testing.T) {
+	t.Parallel()
+	k := newFakeKafka(2)
+	k.setTopic("test", 0, 1)
+	c := New(k.newBroker, []string{"seed:9092"})
+	defer c.Close()
+	if _, err := c.Leader("test", 1); err != nil {
+		t.Fatal(err)
+	}
+	if addrs, expected := poolAddrs(c), []string{fakeAddr(9000), fakeAddr(9001)}; !reflect.DeepEqual(addrs, expected) {
+		t.Fatalf("expect %v but got %v", expected, addrs)
+	}
+
+	// broker 0 is decommissioned and broker 1 moves to another host
+	k.setBrokers(proto.Broker{NodeID: 1, Host: "fake", Port: 9011})
+	k.setTopic("test", 1, 1)
+	if _, err := c.Topics(); err != nil {
+		t.Fatal(err)
+	}
+	if addrs, expected := poolAddrs(c), []string{fakeAddr(9011)}; !reflect.DeepEqual(addrs, expected) {
+		t.Fatalf("expect %v but got %v", expected, addrs)
+	}
+	if !k.isClosed(fakeAddr(9000)) || !k.isClosed(fakeAddr(9001)) {
+		t.Fatal("departed brokers should be closed")
+	}
+	leader, err := c.Leader("test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := leader.(*fakeBroker).addr; addr != fakeAddr(9011) {
+		t.Fatalf("expect leader %s but got %s", fakeAddr(9011), addr)
+	}
+}
+
+func TestRebootstrap(t *testing.T) {
+	t.Parallel()
+	k := newFakeKafka(1)
+	k.setTopic("test", 0)
+	c := New(k.newBroker, []string{"seed:9092"})
+	defer c.Close()
+	c.pool.lookupHost = func(host string) ([]string, error) {
+		if host != "seed" {
+			return nil, errors.New("unknown host")
+		}
+		return []string{"10.0.0.1", "10.0.0.2"}, nil
+	}
+	if _, err := c.Topics(); err != nil {
+		t.Fatal(err)
+	}
+
+	k.setDown(fakeAddr(9000), true)
+	k.setDown("10.0.0.1:9092", true)
+	if _, err := c.Topics(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,57 @@
+package cluster
+
+import (
+	"time"
+
+	"h12.io/kpax/log"
+)
+
+// StartRefresh reloads the leaders, partitions and broker addresses of all
+// known topics every interval in the background until Close is called. A
+// non-positive interval disables the refresh.
+func (c *C) StartRefresh(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.quit != nil {
+		return
+	}
+	c.quit = make(chan struct{})
+	c.done = make(chan struct{})
+	go c.refreshLoop(interval, c.quit, c.done)
+}
+
+func (c *C) stopRefresh() {
+	c.mu.Lock()
+	quit, done := c.quit, c.done
+	c.quit, c.done = nil, nil
+	c.mu.Unlock()
+	if quit != nil {
+		close(quit)
+		<-done
+	}
+}
+
+func (c *C) refreshLoop(interval time.Duration, quit, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			c.refresh()
+		}
+	}
+}
+
+func (c *C) refresh() {
+	for _, topic := range c.topics.getTopics() {
+		if err := c.updateFromTopicMetadata(topic); err != nil {
+			log.Warnf("fail to refresh metadata of topic %s: %v", topic, err)
+		}
+	}
+}
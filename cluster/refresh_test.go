@@ -0,0 +1,53 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRefreshPartitions(t *testing.T) {
+	t.Parallel()
+	k := newFakeKafka(2)
+	k.setTopic("test", 0)
+	c := New(k.newBroker, k.addrs())
+	defer c.Close()
+	if partitions, err := c.Partitions("test"); err != nil || len(partitions) != 1 {
+		t.Fatalf("expect 1 partition but got %v, %v", partitions, err)
+	}
+
+	k.setTopic("test", 0, 1)
+	c.StartRefresh(time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for {
+		partitions, err := c.Partitions("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(partitions) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect 2 partitions after refresh but got %v", partitions)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	leader, err := c.Leader("test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := leader.(*fakeBroker).addr; addr != fakeAddr(9001) {
+		t.Fatalf("expect leader %s but got %s", fakeAddr(9001), addr)
+	}
+}
+
+func TestRefreshDisabled(t *testing.T) {
+	t.Parallel()
+	k := newFakeKafka(1)
+	k.setTopic("test", 0)
+	c := New(k.newBroker, k.addrs())
+	c.StartRefresh(0)
+	c.StartRefresh(-time.Second)
+	// started after the disabled ones
+	c.StartRefresh(time.Millisecond)
+	c.Close()
+}
@@ -0,0 +1,96 @@
+package cluster
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+
+	"h12.io/kpax/log"
+	"h12.io/kpax/model"
+)
+
+// Snapshot is the cached metadata of a cluster that can be saved as JSON and
+// used to warm up a new cluster without a metadata round trip per topic.
+type Snapshot struct {
+	Time    time.Time
+	Brokers []model.BrokerInfo
+	Topics  map[string][]model.PartitionInfo
+}
+
+// Snapshot returns the cached brokers and topics.
+func (c *C) Snapshot() *Snapshot {
+	s := &Snapshot{
+		Time:    time.Now(),
+		Brokers: c.pool.BrokerInfos(),
+		Topics:  make(map[string][]model.PartitionInfo),
+	}
+	for _, topic := range c.topics.getTopics() {
+		if infos := c.topics.getInfos(topic); len(infos) > 0 {
+			s.Topics[topic] = infos
+		}
+	}
+	return s
+}
+
+// WriteSnapshot writes the snapshot of the cluster as JSON.
+func (c *C) WriteSnapshot(w io.Writer) error {
+	return json.NewEncoder(w).Encode(c.Snapshot())
+}
+
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	var s Snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Seed fills the cache with a snapshot. The seeded topics are stale but
+// usable: on the first error, when a leader, coordinator or the controller is
+// reported down or a metadata lookup fails, all of them are reloaded in the
+// background.
+func (c *C) Seed(s *Snapshot) {
+	if len(s.Brokers) > 0 {
+		idAddr := make(map[int32]string, len(s.Brokers))
+		for _, b := range s.Brokers {
+			idAddr[b.ID] = b.Addr
+		}
+		c.pool.Reconcile(idAddr)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stale == nil {
+		c.stale = make(map[string]bool)
+	}
+	for topic, infos := range s.Topics {
+		for _, info := range infos {
+			c.pool.SetLeader(topic, info.ID, info.Leader)
+		}
+		c.topics.addPartitions(topic, infos)
+		c.stale[topic] = true
+	}
+}
+
+// revalidate reloads the seeded topics in the background once.
+func (c *C) revalidate() {
+	c.mu.Lock()
+	stale := c.stale
+	c.stale = nil
+	c.mu.Unlock()
+	if len(stale) == 0 {
+		return
+	}
+	topics := make([]string, 0, len(stale))
+	for topic := range stale {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	go func() {
+		for _, topic := range topics {
+			if err := c.updateFromTopicMetadata(topic); err != nil {
+				log.Warnf("fail to revalidate metadata of topic %s: %v", topic, err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,90 @@
+package cluster
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+	k := newFakeKafka(2)
+	k.setTopic("test", 0, 1)
+	c := New(k.newBroker, k.addrs())
+	if _, err := c.Partitions("test"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := c.WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	snapshot, err := ReadSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := k.calls()
+	c = New(k.newBroker, []string{"seed:9092"})
+	defer c.Close()
+	c.Seed(snapshot)
+	leader, err := c.Leader("test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := leader.(*fakeBroker).addr; addr != fakeAddr(9001) {
+		t.Fatalf("expect leader %s but got %s", fakeAddr(9001), addr)
+	}
+	if k.calls() != calls {
+		t.Fatal("seeded metadata should be used without a metadata request")
+	}
+
+	// leader moved since the snapshot was taken
+	k.setTopic("test", 1, 0)
+	c.LeaderIsDown("test", 1)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if infos := c.topics.getInfos("test"); infos[0].Leader == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stale topic should be revalidated in the background")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSnapshotRevalidateOnFirstError(t *testing.T) {
+	t.Parallel()
+	for name, fail := range map[string]func(c *C){
+		"coordinator":             func(c *C) { c.CoordinatorIsDown("g") },
+		"transaction coordinator": func(c *C) { c.TransactionCoordinatorIsDown("txn") },
+		"controller":              func(c *C) { c.ControllerIsDown() },
+		"partitions": func(c *C) {
+			if _, err := c.Partitions("missing"); err == nil {
+				t.Fatal("expect an error for a missing topic")
+			}
+		},
+	} {
+		k := newFakeKafka(2)
+		k.setTopic("test", 0, 1)
+		c := New(k.newBroker, k.addrs())
+		if _, err := c.Partitions("test"); err != nil {
+			t.Fatal(err)
+		}
+		snapshot := c.Snapshot()
+		c.Close()
+
+		c = New(k.newBroker, k.addrs())
+		c.Seed(snapshot)
+		k.setTopic("test", 1, 0)
+		fail(c)
+		for deadline := time.Now().Add(time.Second); c.topics.getInfos("test")[0].Leader != 1; {
+			if time.Now().After(deadline) {
+				t.Fatalf("stale topic should be revalidated after the %s error", name)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		c.Close()
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
@@ -10,6 +11,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"h12.io/kpax/cluster"
 	"h12.io/kpax/consumer"
 	"h12.io/kpax/model"
 	"h12.io/kpax/producer"
@@ -30,8 +32,9 @@ type Config struct {
 	Offset   OffsetCommand   `command:"offset"   description:"print stored offsets of a topic and group"`
 	Rollback RollbackCommand `command:"rollback" description:"commit offsets of a specific time for a topic"`
 
-	Meta  MetaCommand `command:"meta"`
-	Coord CoordConfig `command:"coord"`
+	Meta   MetaCommand   `command:"meta" description:"print brokers and partitions of a topic or the whole cluster"`
+	Health HealthCommand `command:"health" description:"probe all brokers and report unhealthy partitions"`
+	Coord  CoordConfig   `command:"coord"`
 }
 
 type Brokers []string
@@ -261,24 +264,71 @@ func (cmd *ProduceCommand) Exec(cl model.Cluster) error {
 	if err != nil {
 		return err
 	}
-	return pr.Produce(cmd.Topic, nil, value)
+	delivery, err := pr.Produce(cmd.Topic, nil, value)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("produced to partition %d at offset %d\n", delivery.Partition, delivery.Offset)
+	return nil
 }
 
 type MetaCommand struct {
 	Topic     string `long:"topic"`
-	Partition int    `long:"partition"`
+	Partition int    `long:"partition" default:"-1"`
+}
+
+type topology struct {
+	Brokers []model.BrokerInfo
+	Topics  map[string][]model.PartitionInfo
 }
 
 func (cmd *MetaCommand) Exec(cl model.Cluster) error {
-	b, err := cl.Leader(cmd.Topic, int32(cmd.Partition))
+	brokers, err := cl.Brokers()
 	if err != nil {
 		return err
 	}
-	res, err := proto.Metadata(cmd.Topic).Fetch(b)
+	topics := []string{cmd.Topic}
+	if cmd.Topic == "" {
+		topics, err = cl.Topics()
+		if err != nil {
+			return err
+		}
+	}
+	res := topology{
+		Brokers: brokers,
+		Topics:  make(map[string][]model.PartitionInfo),
+	}
+	for _, topic := range topics {
+		partitions, err := cl.PartitionInfos(topic)
+		if err != nil {
+			return err
+		}
+		if cmd.Partition != -1 {
+			var filtered []model.PartitionInfo
+			for _, partition := range partitions {
+				if partition.ID == int32(cmd.Partition) {
+					filtered = append(filtered, partition)
+				}
+			}
+			partitions = filtered
+		}
+		res.Topics[topic] = partitions
+	}
+	fmt.Println(toJSON(res))
+	return nil
+}
+
+type HealthCommand struct{}
+
+func (cmd *HealthCommand) Exec(cl *cluster.C) error {
+	report, err := cl.Health()
 	if err != nil {
 		return err
 	}
-	fmt.Println(toJSON(res))
+	fmt.Println(toJSON(report))
+	if !report.Healthy() {
+		return errors.New("cluster is unhealthy")
+	}
 	return nil
 }
 
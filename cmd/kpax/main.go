@@ -32,6 +32,8 @@ func main() {
 		err = cfg.Produce.Exec(c)
 	case "meta":
 		err = cfg.Meta.Exec(c)
+	case "health":
+		err = cfg.Health.Exec(c)
 	default:
 		log.Fatal("unkown command " + cmd.Name)
 	}
@@ -0,0 +1,158 @@
+package consumer
+
+import (
+	"sync"
+	"time"
+
+	"h12.io/kpax/proto"
+)
+
+// Reassembler joins the chunks of the values sent by a producer with
+// ChunkSize, so that each value is returned once when all of its chunks are
+// added. Chunks of different values may be interleaved, and the chunks of a
+// value not completed within Timeout are dropped. The zero value is ready to
+// use.
+type Reassembler struct {
+	Timeout   time.Duration // DefaultReassembleTimeout if not positive
+	pending   map[chunkKey]*chunkedValue
+	completed map[chunkKey]time.Time // to drop the duplicated chunks of a completed value
+	now       func() time.Time
+	mu        sync.Mutex
+}
+
+// DefaultReassembleTimeout is used for a non-positive Timeout, because
+// without a timeout the incomplete and completed values are kept forever.
+const DefaultReassembleTimeout = time.Minute
+
+type chunkKey struct {
+	topic     string
+	partition int32
+	id        proto.ChunkID
+}
+
+type chunkedValue struct {
+	chunks      [][]byte
+	received    int
+	firstOffset int64 // the smallest offset of the chunks received
+	start       time.Time
+}
+
+// NewReassembler returns a Reassembler that drops incomplete values after
+// the timeout, or DefaultReassembleTimeout if it is not positive.
+func NewReassembler(timeout time.Duration) *Reassembler {
+	if timeout <= 0 {
+		timeout = DefaultReassembleTimeout
+	}
+	return &Reassembler{
+		Timeout:   timeout,
+		pending:   make(map[chunkKey]*chunkedValue),
+		completed: make(map[chunkKey]time.Time),
+		now:       time.Now,
+	}
+}
+
+// Add adds a message consumed from the partition of the topic. A message that
+// is not a chunk is returned as it is. The chunk of a value is buffered and
+// false is returned, until the last missing chunk is added and the message
+// with the complete value is returned, whose Offset is the offset of that
+// chunk.
+func (r *Reassembler) Add(topic string, partition int32, m Message) (Message, bool) {
+	var c proto.Chunk
+	if !c.Unmarshal(m.Value) {
+		return m, true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.clock()
+	r.expire(now)
+	if r.pending == nil {
+		r.pending = make(map[chunkKey]*chunkedValue)
+		r.completed = make(map[chunkKey]time.Time)
+	}
+	key := chunkKey{topic, partition, c.ID}
+	if _, ok := r.completed[key]; ok {
+		return Message{}, false
+	}
+	v := r.pending[key]
+	if v == nil {
+		v = &chunkedValue{
+			chunks:      make([][]byte, c.Count),
+			firstOffset: m.Offset,
+			start:       now,
+		}
+		r.pending[key] = v
+	}
+	if int(c.Count) != len(v.chunks) || v.chunks[c.Index] != nil {
+		// a duplicate or a chunk of another value with the same ID
+		return Message{}, false
+	}
+	v.chunks[c.Index] = c.Data
+	v.received++
+	if m.Offset < v.firstOffset {
+		v.firstOffset = m.Offset
+	}
+	if v.received < len(v.chunks) {
+		return Message{}, false
+	}
+	delete(r.pending, key)
+	r.completed[key] = now
+	size := 0
+	for _, chunk := range v.chunks {
+		size += len(chunk)
+	}
+	value := make([]byte, 0, size)
+	for _, chunk := range v.chunks {
+		value = append(value, chunk...)
+	}
+	return Message{Key: m.Key, Value: value, Offset: m.Offset}, true
+}
+
+func (r *Reassembler) clock() time.Time {
+	if r.now == nil {
+		return time.Now()
+	}
+	return r.now()
+}
+
+// expire drops the incomplete values older than Timeout, and forgets the
+// completed ones after Timeout. It must be called with the lock held.
+func (r *Reassembler) expire(now time.Time) {
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultReassembleTimeout
+	}
+	for key, v := range r.pending {
+		if now.Sub(v.start) > timeout {
+			delete(r.pending, key)
+		}
+	}
+	for key, t := range r.completed {
+		if now.Sub(t) > timeout {
+			delete(r.completed, key)
+		}
+	}
+}
+
+// CommitOffset returns the offset to commit for the partition of the topic
+// instead of offset, which is before the first chunk of any incomplete value,
+// so that the chunks are consumed again after a restart. The values expired
+// do not hold the offset back.
+func (r *Reassembler) CommitOffset(topic string, partition int32, offset int64) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire(r.clock())
+	for key, v := range r.pending {
+		if key.topic == topic && key.partition == partition && v.firstOffset < offset {
+			offset = v.firstOffset
+		}
+	}
+	return offset
+}
+
+// Pending returns the number of incomplete values.
+func (r *Reassembler) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire(r.clock())
+	return len(r.pending)
+}
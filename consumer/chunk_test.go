@@ -0,0 +1,121 @@
+package consumer
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"h12.io/kpax/proto"
+)
+
+func chunkMessages(t *testing.T, value []byte, size int, offsets ...int64) []Message {
+	chunks, err := proto.SplitChunks(value, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make([]Message, len(chunks))
+	for i := range chunks {
+		messages[i] = Message{Key: []byte("k"), Value: chunks[i].Marshal(), Offset: offsets[i]}
+	}
+	return messages
+}
+
+func TestReassembleInterleaved(t *testing.T) {
+	t.Parallel()
+	r := NewReassembler(time.Minute)
+	a := bytes.Repeat([]byte("a"), 25)
+	b := bytes.Repeat([]byte("b"), 15)
+	ma := chunkMessages(t, a, 10, 0, 2, 5)
+	mb := chunkMessages(t, b, 10, 1, 4)
+	plain := Message{Value: []byte("plain"), Offset: 3}
+	var res []Message
+	for _, m := range []Message{ma[0], mb[0], ma[1], plain, mb[1], ma[2], mb[1]} {
+		if m, ok := r.Add("t", 0, m); ok {
+			res = append(res, m)
+		}
+		if len(res) == 2 {
+			if offset := r.CommitOffset("t", 0, 5); offset != 0 {
+				t.Fatalf("expect commit offset 0 before a is complete but got %d", offset)
+			}
+		}
+	}
+	if len(res) != 3 {
+		t.Fatalf("expect 3 messages but got %d", len(res))
+	}
+	if string(res[0].Value) != "plain" || !bytes.Equal(res[1].Value, b) || !bytes.Equal(res[2].Value, a) {
+		t.Fatalf("unexpected messages %v", res)
+	}
+	if res[1].Offset != 4 || res[2].Offset != 5 || string(res[2].Key) != "k" {
+		t.Fatalf("unexpected messages %v", res)
+	}
+	if r.Pending() != 0 || r.CommitOffset("t", 0, 6) != 6 {
+		t.Fatal("expect no pending value")
+	}
+}
+
+func TestReassembleTimeout(t *testing.T) {
+	t.Parallel()
+	r := NewReassembler(time.Minute)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+	m := chunkMessages(t, []byte("0123456789"), 5, 0, 1)
+	if _, ok := r.Add("t", 0, m[0]); ok || r.Pending() != 1 {
+		t.Fatal("expect the first chunk buffered")
+	}
+	now = now.Add(2 * time.Minute)
+	if _, ok := r.Add("t", 0, m[1]); ok {
+		t.Fatal("expect the expired value dropped")
+	}
+	// the second chunk starts a new value that is never completed
+	if r.Pending() != 1 || r.CommitOffset("t", 0, 2) != 1 {
+		t.Fatal("expect only the second chunk pending")
+	}
+	now = now.Add(2 * time.Minute)
+	if r.Pending() != 0 || r.CommitOffset("t", 0, 2) != 2 {
+		t.Fatal("expect all values expired")
+	}
+}
+
+func TestReassembleNoTimeout(t *testing.T) {
+	t.Parallel()
+	if r := NewReassembler(0); r.Timeout != DefaultReassembleTimeout {
+		t.Fatalf("expect the default timeout but got %v", r.Timeout)
+	}
+	// also set after NewReassembler
+	zero := NewReassembler(time.Minute)
+	zero.Timeout = 0
+	for _, r := range []*Reassembler{NewReassembler(-1), zero} {
+		now := time.Now()
+		r.now = func() time.Time { return now }
+		m := chunkMessages(t, []byte("0123456789"), 5, 0, 1)
+		if _, ok := r.Add("t", 0, m[0]); ok || r.Pending() != 1 {
+			t.Fatal("expect the first chunk buffered")
+		}
+		m = chunkMessages(t, []byte("abcdef"), 5, 2, 3)
+		r.Add("t", 0, m[0])
+		if _, ok := r.Add("t", 0, m[1]); !ok || len(r.completed) != 1 {
+			t.Fatal("expect a complete value")
+		}
+		// both the incomplete and the completed values are dropped eventually
+		now = now.Add(DefaultReassembleTimeout + time.Second)
+		if r.Pending() != 0 || len(r.completed) != 0 {
+			t.Fatalf("expect values expired but got %d pending and %d completed", r.Pending(), len(r.completed))
+		}
+	}
+}
+
+func TestReassembleZeroValue(t *testing.T) {
+	t.Parallel()
+	var r Reassembler
+	if r.Pending() != 0 || r.CommitOffset("t", 0, 5) != 5 {
+		t.Fatal("expect nothing pending")
+	}
+	value := []byte("0123456789")
+	m := chunkMessages(t, value, 5, 0, 1)
+	if _, ok := r.Add("t", 0, m[0]); ok || r.Pending() != 1 {
+		t.Fatal("expect the first chunk buffered")
+	}
+	if got, ok := r.Add("t", 0, m[1]); !ok || !bytes.Equal(got.Value, value) {
+		t.Fatal("expect the value joined")
+	}
+}
@@ -6,6 +6,7 @@ import (
 
 	"h12.io/kpax/model"
 	"h12.io/kpax/proto"
+	"h12.io/kpax/ratelimit"
 )
 
 var (
@@ -24,6 +25,8 @@ type C struct {
 	MinBytes        int
 	MaxBytes        int
 	OffsetRetention time.Duration
+	ReadCommitted   bool               // skips the messages of aborted transactions, requires Kafka 0.11 or later
+	Limiter         *ratelimit.Limiter // optional, limits the messages and bytes per second consumed
 	Cluster         model.Cluster
 }
 
@@ -57,18 +60,26 @@ func (c *C) Offset(topic string, partition int32, consumerGroup string) (int64,
 	return (&proto.Offset{Topic: topic, Partition: partition, Group: consumerGroup}).Fetch(c.Cluster)
 }
 
+// Consume fetches the messages from the offset. With Limiter, it waits until
+// the messages fetched before are paid off, because the size of a fetch is
+// only known afterwards.
 func (c *C) Consume(topic string, partition int32, offset int64) (messages []Message, err error) {
+	if c.Limiter != nil {
+		c.Limiter.Wait(topic, 0, 0)
+	}
 	ms, err := (&proto.Messages{
-		Topic:       topic,
-		Partition:   partition,
-		Offset:      offset,
-		MinBytes:    c.MinBytes,
-		MaxBytes:    c.MaxBytes,
-		MaxWaitTime: c.MaxWaitTime,
+		Topic:         topic,
+		Partition:     partition,
+		Offset:        offset,
+		MinBytes:      c.MinBytes,
+		MaxBytes:      c.MaxBytes,
+		MaxWaitTime:   c.MaxWaitTime,
+		ReadCommitted: c.ReadCommitted,
 	}).Consume(c.Cluster)
 	if err != nil {
 		return nil, err
 	}
+	bytes := 0
 	for i := range ms {
 		m := &ms[i].SizedMessage.CRCMessage.Message
 		messages = append(messages, Message{
@@ -76,6 +87,11 @@ func (c *C) Consume(topic string, partition int32, offset int64) (messages []Mes
 			Value:  m.Value,
 			Offset: ms[i].Offset,
 		})
+		bytes += len(m.Key) + len(m.Value)
+	}
+	if c.Limiter != nil {
+		// waited by the next Consume
+		c.Limiter.Reserve(topic, len(messages), bytes)
 	}
 	return
 }
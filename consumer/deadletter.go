@@ -0,0 +1,92 @@
+package consumer
+
+import (
+	"time"
+
+	"h12.io/kpax/log"
+	"h12.io/kpax/producer"
+	"h12.io/kpax/proto"
+)
+
+// DeadLetterQueue writes the messages that a handler fails to process to a
+// dead-letter topic, and replays them back to the topics they come from. The
+// producer may share the same topic as its DeadLetterTopic.
+type DeadLetterQueue struct {
+	Topic        string
+	Retries      int           // retries of the handler before a message is written to Topic
+	RetryBackoff time.Duration // between the retries of the handler
+	Consumer     *C
+	Producer     *producer.P
+}
+
+func NewDeadLetterQueue(topic string, c *C, p *producer.P) *DeadLetterQueue {
+	return &DeadLetterQueue{
+		Topic:        topic,
+		Retries:      3,
+		RetryBackoff: 100 * time.Millisecond,
+		Consumer:     c,
+		Producer:     p,
+	}
+}
+
+// Handle calls handler with a message consumed from the partition of the
+// topic until it succeeds or fails Retries+1 times. A message that still
+// fails is written to the dead-letter topic and nil is returned, so that the
+// consumer can move on to the next message. An error is returned only if the
+// message cannot be written.
+func (q *DeadLetterQueue) Handle(topic string, partition int32, m Message, handler func(Message) error) error {
+	var err error
+	for attempt := 0; attempt <= q.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(q.RetryBackoff)
+		}
+		if err = handler(m); err == nil {
+			return nil
+		}
+	}
+	log.Warnf("fail to process offset %d of partition %d in %s: %v", m.Offset, partition, topic, err)
+	return q.Put(topic, partition, m, err, q.Retries+1)
+}
+
+// Put writes a message failed to be processed to the dead-letter topic, with
+// the error and the number of attempts.
+func (q *DeadLetterQueue) Put(topic string, partition int32, m Message, cause error, attempts int) error {
+	d := proto.DeadLetter{
+		Topic:     topic,
+		Partition: partition,
+		Offset:    m.Offset,
+		Key:       m.Key,
+		Value:     m.Value,
+		Error:     cause.Error(),
+		Attempts:  attempts,
+		Time:      time.Now(),
+	}
+	_, err := q.Producer.Produce(q.Topic, m.Key, d.Marshal())
+	return err
+}
+
+// Replay consumes a partition of the dead-letter topic from the offset to the
+// end, and produces each message back to the partition it comes from. It
+// returns the offset to resume from next time, which is the offset of the
+// message failed to be replayed on error. A message that is not a
+// proto.DeadLetter is skipped.
+func (q *DeadLetterQueue) Replay(partition int32, offset int64) (int64, error) {
+	for {
+		messages, err := q.Consumer.Consume(q.Topic, partition, offset)
+		if err != nil {
+			return offset, err
+		}
+		if len(messages) == 0 {
+			return offset, nil
+		}
+		for _, m := range messages {
+			var d proto.DeadLetter
+			if err := d.Unmarshal(m.Value); err != nil {
+				log.Warnf("skip offset %d of partition %d in %s: %v", m.Offset, partition, q.Topic, err)
+			} else if _, err := q.Producer.ProduceWithPartition(d.Topic, d.Partition, d.Key, d.Value); err != nil {
+				return m.Offset, err
+			}
+			offset = m.Offset + 1
+		}
+	}
+}
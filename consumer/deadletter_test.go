@@ -0,0 +1,61 @@
+package consumer
+
+import (
+	"errors"
+	"testing"
+
+	"h12.io/kpax/producer"
+	"h12.io/kpax/proto"
+)
+
+func TestDeadLetterQueue(t *testing.T) {
+	t.Parallel()
+	c := newFakeCluster()
+	c.setTopic("a", 2)
+	c.setTopic("dlq", 1)
+	q := NewDeadLetterQueue("dlq", New(c), producer.New(c))
+	q.RetryBackoff = 0
+	errFail := errors.New("fail")
+	calls := 0
+	for i, m := range []Message{
+		{Key: []byte("k0"), Value: []byte("ok"), Offset: 10},
+		{Key: []byte("k1"), Value: []byte("bad"), Offset: 11},
+	} {
+		if err := q.Handle("a", 1, m, func(m Message) error {
+			calls++
+			if string(m.Value) == "bad" {
+				return errFail
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+	}
+	if calls != 1+q.Retries+1 {
+		t.Fatalf("expect %d calls but got %d", 1+q.Retries+1, calls)
+	}
+	m := c.broker.messages("dlq", 0)
+	if len(m) != 1 {
+		t.Fatalf("expect 1 dead letter but got %d", len(m))
+	}
+	var d proto.DeadLetter
+	if err := d.Unmarshal(m[0].Value); err != nil {
+		t.Fatal(err)
+	}
+	if d.Topic != "a" || d.Partition != 1 || d.Offset != 11 || string(d.Key) != "k1" || string(d.Value) != "bad" ||
+		d.Error != "fail" || d.Attempts != q.Retries+1 {
+		t.Fatalf("unexpected dead letter %v", d)
+	}
+
+	offset, err := q.Replay(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 1 {
+		t.Fatalf("expect next offset 1 but got %d", offset)
+	}
+	replayed := c.broker.messages("a", 1)
+	if len(replayed) != 1 || string(replayed[0].Key) != "k1" || string(replayed[0].Value) != "bad" {
+		t.Fatalf("unexpected replayed messages %v", replayed)
+	}
+}
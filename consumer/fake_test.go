@@ -0,0 +1,130 @@
+package consumer
+
+import (
+	"errors"
+	"sync"
+
+	"h12.io/kpax/model"
+	"h12.io/kpax/proto"
+)
+
+var errNoLeader = errors.New("fake cluster: no leader")
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// fakeCluster has topics with partitions led by one in-memory broker
+type fakeCluster struct {
+	broker     *fakeBroker
+	partitions map[string]int
+	mu         sync.Mutex
+}
+
+func newFakeCluster() *fakeCluster {
+	return &fakeCluster{
+		broker:     &fakeBroker{logs: make(map[topicPartition][]proto.Message)},
+		partitions: make(map[string]int),
+	}
+}
+
+func (c *fakeCluster) setTopic(topic string, partitions int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.partitions[topic] = partitions
+}
+
+func (c *fakeCluster) Leader(topic string, partition int32) (model.Broker, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if int(partition) >= c.partitions[topic] {
+		return nil, errNoLeader
+	}
+	return c.broker, nil
+}
+
+func (c *fakeCluster) Partitions(topic string) ([]int32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, ok := c.partitions[topic]
+	if !ok {
+		return nil, proto.ErrUnknownTopicOrPartition
+	}
+	partitions := make([]int32, n)
+	for i := range partitions {
+		partitions[i] = int32(i)
+	}
+	return partitions, nil
+}
+
+func (c *fakeCluster) LeaderIsDown(topic string, partition int32)     {}
+func (c *fakeCluster) Coordinator(group string) (model.Broker, error) { return nil, errNoLeader }
+func (c *fakeCluster) CoordinatorIsDown(group string)                 {}
+func (c *fakeCluster) TransactionCoordinator(id string) (model.Broker, error) {
+	return nil, errNoLeader
+}
+func (c *fakeCluster) TransactionCoordinatorIsDown(id string)                     {}
+func (c *fakeCluster) Controller() (model.Broker, error)                          { return nil, errNoLeader }
+func (c *fakeCluster) ControllerIsDown()                                          {}
+func (c *fakeCluster) PartitionInfos(topic string) ([]model.PartitionInfo, error) { return nil, nil }
+func (c *fakeCluster) Topics() ([]string, error)                                  { return nil, nil }
+func (c *fakeCluster) Brokers() ([]model.BrokerInfo, error)                       { return nil, nil }
+
+// fakeBroker appends produced messages to in-memory partition logs and
+// fetches them back
+type fakeBroker struct {
+	logs map[topicPartition][]proto.Message
+	mu   sync.Mutex
+}
+
+func (b *fakeBroker) messages(topic string, partition int32) []proto.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]proto.Message(nil), b.logs[topicPartition{topic, partition}]...)
+}
+
+func (b *fakeBroker) Do(req model.Request, resp model.Response) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch r := req.(*proto.Request).RequestMessage.(type) {
+	case *proto.ProduceRequest:
+		m := resp.(*proto.Response).ResponseMessage.(*proto.ProduceResponse)
+		for _, t := range r.MessageSetInTopics {
+			ot := proto.OffsetInTopic{TopicName: t.TopicName}
+			for _, p := range t.MessageSetInPartitions {
+				tp := topicPartition{t.TopicName, p.Partition}
+				ot.OffsetInPartitions = append(ot.OffsetInPartitions, proto.OffsetInPartition{
+					Partition: p.Partition,
+					Offset:    int64(len(b.logs[tp])),
+				})
+				for i := range p.MessageSet {
+					b.logs[tp] = append(b.logs[tp], p.MessageSet[i].Message)
+				}
+			}
+			*m = append(*m, ot)
+		}
+	case *proto.FetchRequest:
+		m := resp.(*proto.Response).ResponseMessage.(*proto.FetchResponse)
+		for _, t := range r.FetchOffsetInTopics {
+			ft := proto.FetchMessageSetInTopic{TopicName: t.TopicName}
+			for _, p := range t.FetchOffsetInPartitions {
+				log := b.logs[topicPartition{t.TopicName, p.Partition}]
+				fp := proto.FetchMessageSetInPartition{Partition: p.Partition, HighwaterMarkOffset: int64(len(log))}
+				for offset := p.FetchOffset; offset < int64(len(log)); offset++ {
+					var om proto.OffsetMessage
+					om.Offset = offset
+					om.Message = log[offset]
+					fp.MessageSet = append(fp.MessageSet, om)
+				}
+				ft.FetchMessageSetInPartitions = append(ft.FetchMessageSetInPartitions, fp)
+			}
+			*m = append(*m, ft)
+		}
+	default:
+		return errors.New("fake broker: unsupported request")
+	}
+	return nil
+}
+
+func (b *fakeBroker) Close() {}
@@ -0,0 +1,41 @@
+package consumer
+
+import (
+	"testing"
+	"time"
+
+	"h12.io/kpax/producer"
+	"h12.io/kpax/ratelimit"
+)
+
+func TestLimiter(t *testing.T) {
+	t.Parallel()
+	c := newFakeCluster()
+	c.setTopic("a", 1)
+	p := producer.New(c)
+	for i := 0; i < 60; i++ {
+		if _, err := p.Produce("a", nil, []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cr := New(c)
+	cr.Limiter = ratelimit.New(ratelimit.Rate{Messages: 40})
+	start := time.Now()
+	messages, err := cr.Consume("a", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 60 {
+		t.Fatalf("expect 60 messages but got %d", len(messages))
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("expect the first fetch not limited but took %v", elapsed)
+	}
+	// waits for the debt of 20 messages at 40 per second
+	if _, err := cr.Consume("a", 0, 60); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("expect the second fetch limited but took %v", elapsed)
+	}
+}
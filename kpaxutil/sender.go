@@ -25,5 +25,6 @@ func (s *simpleSender) Send(topic string, value encoding.BinaryMarshaler) error
 	if err != nil {
 		return err
 	}
-	return s.p.Produce(topic, nil, buf)
+	_, err = s.p.Produce(topic, nil, buf)
+	return err
 }
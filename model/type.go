@@ -12,9 +12,28 @@ type Broker interface {
 type Cluster interface {
 	Coordinator(group string) (Broker, error)
 	CoordinatorIsDown(group string)
+	TransactionCoordinator(transactionalID string) (Broker, error)
+	TransactionCoordinatorIsDown(transactionalID string)
+	Controller() (Broker, error)
+	ControllerIsDown()
 	Leader(topic string, partition int32) (Broker, error)
 	LeaderIsDown(topic string, partition int32)
 	Partitions(topic string) ([]int32, error)
+	PartitionInfos(topic string) ([]PartitionInfo, error)
+	Topics() ([]string, error)
+	Brokers() ([]BrokerInfo, error)
+}
+
+type BrokerInfo struct {
+	ID   int32
+	Addr string
+}
+
+type PartitionInfo struct {
+	ID       int32
+	Leader   int32 // -1 if the partition is offline
+	Replicas []int32
+	ISR      []int32
 }
 
 type Request interface {
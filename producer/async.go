@@ -0,0 +1,429 @@
+package producer
+
+import (
+	"sync"
+	"time"
+
+	"h12.io/kpax/log"
+	"h12.io/kpax/model"
+	"h12.io/kpax/proto"
+)
+
+// messageOverhead is the size of a message in a message set besides its key
+// and value: offset, size, CRC, magic byte, attributes, key and value length.
+const messageOverhead = 26
+
+// Record is a message sent asynchronously. Partition, Offset and Err are set
+// before it is reported. Offset is -1 on error or if RequiredAcks is AckNone.
+type Record struct {
+	Topic        string
+	Partition    int32
+	Offset       int64
+	Key          []byte
+	Value        []byte
+	Err          error
+	Spooled      bool // written to P.Spool after failing to be sent or behind earlier spooled messages, Err is nil
+	DeadLettered bool // written to P.DeadLetterTopic after failing with Err
+	callback     func(*Record)
+	sync         bool           // of a synchronous Produce, which returns the error instead of reporting it
+	unreported   bool           // not reported to P.Reports
+	parent       *chunkedRecord // the record sent in chunks if it is a chunk
+}
+
+func (r *Record) size() int {
+	return messageOverhead + len(r.Key) + len(r.Value)
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+type batch struct {
+	topicPartition
+	id       int // in the order of creation
+	records  []*Record
+	size     int
+	timer    *time.Timer
+	attempts int
+	config   topicSettings // of the topic when the batch is created
+	charged  bool          // taken from P.Limiter
+	sent     bool          // taken by send at least once
+
+	// only for the idempotent producer
+	pid        proto.ProducerID
+	sequence   int32
+	generation int
+}
+
+func (b *batch) messageSet() proto.MessageSet {
+	ms := make(proto.MessageSet, len(b.records))
+	for i, r := range b.records {
+		ms[i].Message = proto.Message{
+			Key:   r.Key,
+			Value: r.Value,
+		}
+	}
+	return ms
+}
+
+// accumulator collects records into batches per topic partition, and sends
+// the batches that are full or lingered out.
+type accumulator struct {
+	p        *P
+	batches  map[topicPartition]*batch
+	ready    []*batch
+	readyc   chan struct{} // notifies sendLoop of ready batches
+	inFlight map[topicPartition]bool
+	idem     idempotence
+	pending  int // records not completed yet
+	buffered int // bytes of the records not completed yet
+	nextID   int
+	closed   bool          // refuses new records
+	aborted  bool          // completes the records not sent yet with ErrClosed
+	quit     chan struct{} // stops sendLoop
+	once     sync.Once
+	mu       sync.Mutex
+	cond     sync.Cond
+}
+
+func newAccumulator(p *P) *accumulator {
+	a := &accumulator{
+		p:        p,
+		batches:  make(map[topicPartition]*batch),
+		readyc:   make(chan struct{}, 1),
+		quit:     make(chan struct{}),
+		inFlight: make(map[topicPartition]bool),
+	}
+	a.cond.L = &a.mu
+	return a
+}
+
+// Send sends a message asynchronously. The message is buffered until the
+// batch of its partition reaches BatchSize, lingers for Linger or Flush is
+// called. The record is reported to callback if not nil and then to Reports if
+// not nil. callback must not call Flush. An error is returned when no
+// partition can be chosen, the message exceeds MaxMessageSize or the buffer is
+// full (see BufferMemory), and then the record is not reported. A batch
+// rejected by the broker for its size is split and sent again.
+func (p *P) Send(topic string, key, value []byte, callback func(*Record)) error {
+	partitions, err := p.Cluster.Partitions(topic)
+	if err != nil {
+		return err
+	}
+	partition, err := p.partitioner(topic).Partition(key, partitions)
+	if err != nil {
+		return err
+	}
+	return p.SendWithPartition(topic, partition, key, value, callback)
+}
+
+// SendWithPartition is the same as Send except that the partition is given.
+// A value larger than ChunkSize is sent in chunks, and the record is reported
+// once all of them are completed, with the offset of the first chunk.
+func (p *P) SendWithPartition(topic string, partition int32, key, value []byte, callback func(*Record)) error {
+	if p.chunked(value) {
+		if err := p.checkValueSize(topic, key, value); err != nil {
+			return err
+		}
+		return p.sendChunks(topic, partition, key, value, callback)
+	}
+	return p.acc.add(&Record{
+		Topic:     topic,
+		Partition: partition,
+		Key:       key,
+		Value:     value,
+		callback:  callback,
+	})
+}
+
+// Flush sends all buffered messages and waits until all of them are
+// completed.
+func (p *P) Flush() {
+	p.acc.flush()
+}
+
+func (a *accumulator) add(r *Record) error {
+	if err := a.p.checkSize(r.Topic, r.Key, r.Value); err != nil {
+		return err
+	}
+	a.once.Do(func() { go a.sendLoop() })
+	tp := topicPartition{r.Topic, r.Partition}
+	size := r.size()
+	config := a.p.topicSettings(r.Topic)
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return ErrClosed
+	}
+	dropped, err := a.reserve(size)
+	if err == nil && a.closed {
+		// closed while blocked in reserve
+		err = ErrClosed
+	}
+	if err != nil {
+		a.mu.Unlock()
+		a.completeDropped(dropped)
+		return err
+	}
+	b := a.batches[tp]
+	if b != nil && b.size+size > config.BatchSize {
+		a.seal(b)
+		b = nil
+	}
+	if b == nil {
+		a.nextID++
+		b = &batch{topicPartition: tp, id: a.nextID, config: config}
+		a.batches[tp] = b
+		if config.Linger > 0 {
+			b.timer = time.AfterFunc(config.Linger, func() {
+				a.mu.Lock()
+				defer a.mu.Unlock()
+				if a.batches[tp] == b {
+					a.seal(b)
+				}
+			})
+		}
+	}
+	b.records = append(b.records, r)
+	b.size += size
+	a.pending++
+	a.buffered += size
+	if b.size >= b.config.BatchSize || b.config.Linger <= 0 {
+		a.seal(b)
+	}
+	a.mu.Unlock()
+	a.completeDropped(dropped)
+	return nil
+}
+
+// seal must be called with the lock held.
+func (a *accumulator) seal(b *batch) {
+	delete(a.batches, b.topicPartition)
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	onNewBatch(a.p.topicPartitioner.Get(b.topic, b.config.Partitioner), b.partition)
+	a.enqueue(b)
+}
+
+// enqueue must be called with the lock held.
+func (a *accumulator) enqueue(b *batch) {
+	a.ready = append(a.ready, b)
+	a.notify()
+}
+
+// requeue puts a batch to be retried before the other ready batches of the
+// same partition. It must be called with the lock held.
+func (a *accumulator) requeue(b *batch) {
+	delete(a.inFlight, b.topicPartition)
+	a.ready = append([]*batch{b}, a.ready...)
+	a.notify()
+}
+
+func (a *accumulator) notify() {
+	select {
+	case a.readyc <- struct{}{}:
+	default:
+	}
+}
+
+// takeReady takes at most one ready batch per partition, because a partition
+// should not appear twice in one request. With StrictOrder or Idempotent, a
+// partition with a batch in flight is skipped. It must be called with the lock
+// held.
+func (a *accumulator) takeReady() []*batch {
+	var batches, rest []*batch
+	taken := make(map[topicPartition]bool)
+	for _, b := range a.ready {
+		if taken[b.topicPartition] || ((a.p.StrictOrder || a.p.idempotent()) && a.inFlight[b.topicPartition]) {
+			rest = append(rest, b)
+			continue
+		}
+		taken[b.topicPartition] = true
+		a.inFlight[b.topicPartition] = true
+		batches = append(batches, b)
+	}
+	a.ready = rest
+	if len(batches) > 0 && len(rest) > 0 {
+		a.notify()
+	}
+	return batches
+}
+
+func (a *accumulator) sealPartition(tp topicPartition) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if b := a.batches[tp]; b != nil {
+		a.seal(b)
+	}
+}
+
+func (a *accumulator) flush() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, b := range a.batches {
+		a.seal(b)
+	}
+	for a.pending > 0 {
+		a.cond.Wait()
+	}
+}
+
+func (a *accumulator) sendLoop() {
+	for {
+		select {
+		case <-a.quit:
+			return
+		case <-a.readyc:
+		}
+		a.mu.Lock()
+		batches := a.takeReady()
+		a.mu.Unlock()
+		a.send(batches)
+	}
+}
+
+// requestKey tells which batches can be sent in one request, because acks
+// and timeout are shared by the whole request.
+type requestKey struct {
+	leader  model.Broker
+	acks    proto.ProduceAckType
+	timeout time.Duration
+}
+
+// send groups the batches by their leaders and topic settings, so that
+// batches led by the same broker are sent in one request.
+func (a *accumulator) send(batches []*batch) {
+	requestBatches := make(map[requestKey][]*batch)
+	var keys []requestKey
+	for _, b := range batches {
+		if b = a.divert(b); b == nil {
+			continue
+		}
+		leader, err := a.p.Cluster.Leader(b.topic, b.partition)
+		if err != nil {
+			a.fail(b, err)
+			continue
+		}
+		key := requestKey{leader, b.config.RequiredAcks, b.config.AckTimeout}
+		if _, ok := requestBatches[key]; !ok {
+			keys = append(keys, key)
+		}
+		requestBatches[key] = append(requestBatches[key], b)
+	}
+	for _, key := range keys {
+		go a.produce(key.leader, requestBatches[key])
+	}
+}
+
+func (a *accumulator) produce(leader model.Broker, batches []*batch) {
+	a.waitBatches(batches)
+	if a.p.idempotent() {
+		a.produceRecords(leader, batches)
+		return
+	}
+	payloads := make(proto.Payloads, len(batches))
+	for i, b := range batches {
+		payloads[i] = &proto.Payload{
+			Topic:        b.topic,
+			Partition:    b.partition,
+			MessageSet:   b.messageSet().Compress(b.config.Compression),
+			RequiredAcks: b.config.RequiredAcks,
+			AckTimeout:   b.config.AckTimeout,
+		}
+	}
+	errs := payloads.DoProduce(leader)
+	for i, b := range batches {
+		if errs[i] != nil {
+			a.failOnLeader(b, errs[i])
+			continue
+		}
+		a.complete(b, payloads[i].Offset, nil)
+	}
+}
+
+// failOnLeader marks the leader as down before fail if necessary.
+func (a *accumulator) failOnLeader(b *batch, err error) {
+	if proto.IsNotLeader(err) {
+		a.p.Cluster.LeaderIsDown(b.topic, b.partition)
+	}
+	a.fail(b, err)
+}
+
+// fail retries the batch on the same partition after a backoff if the error
+// is retriable, or completes it with the error. A batch too large is split
+// instead.
+func (a *accumulator) fail(b *batch, err error) {
+	if tooLarge(err) && len(b.records) > 1 {
+		a.split(b, err)
+		return
+	}
+	if !retriable(err) {
+		a.complete(b, -1, err)
+		return
+	}
+	a.retry(b, err)
+}
+
+func (a *accumulator) retry(b *batch, err error) {
+	if b.attempts >= a.p.Retries {
+		a.complete(b, -1, err)
+		return
+	}
+	a.mu.Lock()
+	aborted := a.aborted
+	a.mu.Unlock()
+	if aborted {
+		a.complete(b, -1, ErrClosed)
+		return
+	}
+	log.Warnf("retry producing to partition %d in %s: %v", b.partition, b.topic, err)
+	backoff := a.p.backoff(b.attempts)
+	b.attempts++
+	time.AfterFunc(backoff, func() {
+		a.mu.Lock()
+		if a.aborted {
+			a.mu.Unlock()
+			a.complete(b, -1, ErrClosed)
+			return
+		}
+		a.requeue(b)
+		a.mu.Unlock()
+	})
+}
+
+func (a *accumulator) complete(b *batch, offset int64, err error) {
+	a.report(b, offset, err)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.inFlight, b.topicPartition)
+	if len(a.ready) > 0 {
+		a.notify()
+	}
+	a.pending -= len(b.records)
+	a.buffered -= b.size
+	// wakes up both flush and add blocked on a full buffer
+	a.cond.Broadcast()
+}
+
+func (a *accumulator) report(b *batch, offset int64, err error) {
+	a.spool(b, err)
+	a.deadLetter(b, err)
+	for i, r := range b.records {
+		r.Offset = -1
+		if offset >= 0 {
+			r.Offset = offset + int64(i)
+		}
+		r.Err = err
+		if r.Spooled {
+			r.Err = nil
+		}
+		if r.callback != nil {
+			r.callback(r)
+		}
+		if a.p.Reports != nil && !r.sync && !r.unreported {
+			a.p.Reports <- r
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package producer
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"h12.io/kpax/proto"
+)
+
+type results struct {
+	records []*Record
+	mu      sync.Mutex
+}
+
+func (r *results) add(record *Record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, record)
+}
+
+func (r *results) get() []*Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*Record(nil), r.records...)
+}
+
+func TestSendGroupByLeader(t *testing.T) {
+	t.Parallel()
+	b0, b1 := newFakeBroker(), newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b0, b1)
+	c.setTopic("b", b0)
+	p := New(c)
+	p.Partitioner = NewRoundRobinPartitioner
+	p.Linger = time.Hour
+	var res results
+	for i := 0; i < 10; i++ {
+		if err := p.Send("a", nil, []byte(strconv.Itoa(i)), res.add); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Send("b", nil, []byte(strconv.Itoa(i)), res.add); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Flush()
+	if n := len(res.get()); n != 20 {
+		t.Fatalf("expect 20 results but got %d", n)
+	}
+	for _, r := range res.get() {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+	}
+	// a/0 and b/0 are sent in one request
+	if b0.requestCount() != 1 || b1.requestCount() != 1 {
+		t.Fatalf("expect one request per broker but got %d, %d", b0.requestCount(), b1.requestCount())
+	}
+	for _, m := range [][]proto.Message{b0.messages("a", 0), b1.messages("a", 1)} {
+		if len(m) != 5 {
+			t.Fatalf("expect 5 messages but got %d", len(m))
+		}
+	}
+	bm := b0.messages("b", 0)
+	for i := range bm {
+		if string(bm[i].Value) != strconv.Itoa(i) {
+			t.Fatalf("expect message %d but got %s", i, bm[i].Value)
+		}
+	}
+}
+
+func TestSendBatchSize(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b)
+	p := New(c)
+	p.Linger = time.Hour
+	p.BatchSize = 2 * (messageOverhead + 1)
+	var res results
+	for i := 0; i < 4; i++ {
+		p.Send("a", nil, []byte{'x'}, res.add)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(res.get()) < 4 {
+		if time.Now().After(deadline) {
+			t.Fatal("full batches should be sent without linger")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := len(b.messages("a", 0)); n != 4 {
+		t.Fatalf("expect 4 messages but got %d", n)
+	}
+}
+
+func TestSendLinger(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b)
+	p := New(c)
+	p.Linger = 10 * time.Millisecond
+	done := make(chan *Record, 1)
+	p.Send("a", []byte("k"), []byte("v"), func(r *Record) { done <- r })
+	select {
+	case r := <-done:
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the batch should be sent after lingering")
+	}
+}
+
+func TestSendPartialError(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	b.setError("a", 1, proto.ErrMessageSizeTooLarge)
+	c := newFakeCluster()
+	c.setTopic("a", b, b, nil)
+	p := New(c)
+	p.Linger = time.Hour
+	p.Retries = 0
+	var res results
+	for partition := int32(0); partition < 3; partition++ {
+		p.SendWithPartition("a", partition, nil, []byte("v"), res.add)
+	}
+	p.Flush()
+	errs := make(map[int32]error)
+	for _, r := range res.get() {
+		errs[r.Partition] = r.Err
+	}
+	if len(errs) != 3 || errs[0] != nil || errs[1] != proto.ErrMessageSizeTooLarge || errs[2] != errNoLeader {
+		t.Fatalf("unexpected results %v", errs)
+	}
+}
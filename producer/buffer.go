@@ -0,0 +1,129 @@
+package producer
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrBufferFull = errors.New("producer buffer is full")
+
+// BufferPolicy decides what Send does when BufferMemory is exceeded.
+type BufferPolicy int
+
+const (
+	// BlockOnFull blocks Send until there is enough space or BufferTimeout
+	// elapses, and then returns ErrBufferFull.
+	BlockOnFull BufferPolicy = iota
+	// DropOldestOnFull drops the oldest batches not sent yet to make space,
+	// and reports their records with ErrBufferFull.
+	DropOldestOnFull
+	// FailOnFull returns ErrBufferFull immediately.
+	FailOnFull
+)
+
+// BufferUsage is a snapshot of the messages buffered by Send and not
+// completed yet.
+type BufferUsage struct {
+	Bytes   int
+	Records int
+}
+
+// BufferUsage returns the current usage of the buffer.
+func (p *P) BufferUsage() BufferUsage {
+	a := p.acc
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return BufferUsage{Bytes: a.buffered, Records: a.pending}
+}
+
+// reserve makes space for a record of the size according to BufferPolicy. It
+// returns the dropped batches to be completed after the lock is released. It
+// must be called with the lock held.
+func (a *accumulator) reserve(size int) (dropped []*batch, err error) {
+	limit := a.p.BufferMemory
+	if limit <= 0 || a.buffered+size <= limit {
+		return nil, nil
+	}
+	if size > limit {
+		return nil, ErrBufferFull
+	}
+	switch a.p.BufferPolicy {
+	case DropOldestOnFull:
+		for a.buffered+size > limit {
+			b := a.dropOldest()
+			if b == nil {
+				return dropped, ErrBufferFull
+			}
+			dropped = append(dropped, b)
+		}
+		return dropped, nil
+	case FailOnFull:
+		return nil, ErrBufferFull
+	}
+	// sends the open batches, so that the buffer can be released without
+	// waiting for them to linger out
+	for _, b := range a.batches {
+		a.seal(b)
+	}
+	expired := false
+	timer := time.AfterFunc(a.p.BufferTimeout, func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		expired = true
+		a.cond.Broadcast()
+	})
+	defer timer.Stop()
+	for a.buffered+size > limit {
+		if a.closed {
+			return nil, ErrClosed
+		}
+		if expired {
+			return nil, ErrBufferFull
+		}
+		a.cond.Wait()
+	}
+	return nil, nil
+}
+
+// dropOldest removes the oldest batch that has never been sent, so dropping
+// it does not break the sequence numbers of an idempotent producer. A ready
+// batch is older than an open one. It must be called with the lock held.
+func (a *accumulator) dropOldest() *batch {
+	for i, b := range a.ready {
+		if b.attempts == 0 && b.generation == 0 {
+			a.ready = append(a.ready[:i:i], a.ready[i+1:]...)
+			a.release(b)
+			return b
+		}
+	}
+	var oldest *batch
+	for _, b := range a.batches {
+		if oldest == nil || b.id < oldest.id {
+			oldest = b
+		}
+	}
+	if oldest != nil {
+		delete(a.batches, oldest.topicPartition)
+		if oldest.timer != nil {
+			oldest.timer.Stop()
+		}
+		a.release(oldest)
+	}
+	return oldest
+}
+
+// release must be called with the lock held.
+func (a *accumulator) release(b *batch) {
+	a.buffered -= b.size
+	a.cond.Broadcast()
+}
+
+func (a *accumulator) completeDropped(dropped []*batch) {
+	for _, b := range dropped {
+		a.report(b, -1, ErrBufferFull)
+		a.mu.Lock()
+		a.pending -= len(b.records)
+		a.cond.Broadcast()
+		a.mu.Unlock()
+	}
+}
@@ -0,0 +1,170 @@
+package producer
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// each record of a one byte value without a key takes 27 bytes
+const recordSize = messageOverhead + 1
+
+func newBufferedProducer(c *fakeCluster, policy BufferPolicy) *P {
+	p := New(c)
+	p.Linger = time.Hour
+	p.BufferMemory = 2 * recordSize
+	p.BufferPolicy = policy
+	return p
+}
+
+func TestBufferFailOnFull(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b)
+	p := newBufferedProducer(c, FailOnFull)
+	for i := 0; i < 2; i++ {
+		if err := p.SendWithPartition("a", 0, nil, []byte("v"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if u := p.BufferUsage(); u.Bytes != 2*recordSize || u.Records != 2 {
+		t.Fatalf("unexpected buffer usage %+v", u)
+	}
+	if err := p.SendWithPartition("a", 0, nil, []byte("v"), nil); err != ErrBufferFull {
+		t.Fatalf("expect ErrBufferFull but got %v", err)
+	}
+	if err := p.SendWithPartition("a", 0, nil, make([]byte, 2*recordSize), nil); err != ErrBufferFull {
+		t.Fatalf("expect ErrBufferFull for a record larger than the buffer but got %v", err)
+	}
+	p.Flush()
+	if u := p.BufferUsage(); u.Bytes != 0 || u.Records != 0 {
+		t.Fatalf("expect empty buffer but got %+v", u)
+	}
+	if n := len(b.messages("a", 0)); n != 2 {
+		t.Fatalf("expect 2 messages but got %d", n)
+	}
+}
+
+func TestBufferDropOldestOnFull(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b, b)
+	p := newBufferedProducer(c, DropOldestOnFull)
+	var res results
+	for _, partition := range []int32{0, 1, 1} {
+		if err := p.SendWithPartition("a", partition, nil, []byte{byte('0' + partition)}, res.add); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Flush()
+	rs := res.get()
+	if len(rs) != 3 || rs[0].Partition != 0 || rs[0].Err != ErrBufferFull {
+		t.Fatalf("expect the batch of partition 0 dropped first but got %v", rs)
+	}
+	if len(b.messages("a", 0)) != 0 || len(b.messages("a", 1)) != 2 {
+		t.Fatal("expect only the messages of partition 1 produced")
+	}
+}
+
+func TestBufferBlockOnFull(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b)
+	p := newBufferedProducer(c, BlockOnFull)
+	// the open batch is sent to make space instead of lingering for an hour
+	for i := 0; i < 3; i++ {
+		if err := p.SendWithPartition("a", 0, nil, []byte("v"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Flush()
+	if n := len(b.messages("a", 0)); n != 3 {
+		t.Fatalf("expect 3 messages but got %d", n)
+	}
+}
+
+func TestBufferBlockTimeout(t *testing.T) {
+	t.Parallel()
+	c := newFakeCluster()
+	c.setTopic("a", nil)
+	p := newBufferedProducer(c, BlockOnFull)
+	p.BufferTimeout = 10 * time.Millisecond
+	// the batches are retried without a leader and never released
+	p.RetryBackoff = time.Hour
+	for i := 0; i < 2; i++ {
+		if err := p.SendWithPartition("a", 0, nil, []byte("v"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now()
+	if err := p.SendWithPartition("a", 0, nil, []byte("v"), nil); err != ErrBufferFull {
+		t.Fatalf("expect ErrBufferFull but got %v", err)
+	}
+	if d := time.Since(start); d < p.BufferTimeout {
+		t.Fatalf("expect to block for %v but got %v", p.BufferTimeout, d)
+	}
+}
+
+func TestBufferBlockClose(t *testing.T) {
+	t.Parallel()
+	c := newFakeCluster()
+	c.setTopic("a", nil)
+	p := newBufferedProducer(c, BlockOnFull)
+	p.BufferTimeout = time.Hour
+	p.RetryBackoff = time.Hour
+	for i := 0; i < 2; i++ {
+		if err := p.SendWithPartition("a", 0, nil, []byte("v"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- p.SendWithPartition("a", 0, nil, []byte("v"), nil)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	err := p.Close(10 * time.Millisecond)
+	select {
+	case err := <-errc:
+		if err != ErrClosed {
+			t.Fatalf("expect ErrClosed but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked Send is not woken up by Close")
+	}
+	// the blocked record is not buffered after Close
+	if ce, ok := err.(*CloseError); !ok || len(ce.Undelivered) != 0 || ce.InFlight != 2 {
+		t.Fatalf("unexpected close error %v", err)
+	}
+}
+
+func TestBufferDropOldestNotSpooled(t *testing.T) {
+	t.Parallel()
+	dir := newSpoolDir(t)
+	defer os.RemoveAll(dir)
+	s, err := OpenSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b, b)
+	p := newBufferedProducer(c, DropOldestOnFull)
+	p.Spool = s
+	var res results
+	for _, partition := range []int32{0, 1, 1} {
+		if err := p.SendWithPartition("a", partition, nil, []byte("v"), res.add); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Flush()
+	if rs := res.get(); len(rs) != 3 || rs[0].Err != ErrBufferFull || rs[0].Spooled {
+		t.Fatalf("expect the dropped record reported with ErrBufferFull but got %v", rs)
+	}
+	if n := s.Backlog("a", 0); n != 0 {
+		t.Fatalf("expect nothing spooled but got %d", n)
+	}
+}
@@ -0,0 +1,164 @@
+package producer
+
+import (
+	"sync"
+	"time"
+
+	"h12.io/kpax/log"
+	"h12.io/kpax/proto"
+)
+
+// chunked returns true if the value is split into chunks when sent.
+func (p *P) chunked(value []byte) bool {
+	return p.ChunkSize > 0 && len(value) > p.ChunkSize
+}
+
+// messageSet returns the message set of a message, which has one message per
+// chunk if the value is larger than ChunkSize.
+func (p *P) messageSet(key, value []byte) (proto.MessageSet, error) {
+	if !p.chunked(value) {
+		return getMessageSet(key, value), nil
+	}
+	chunks, err := proto.SplitChunks(value, p.ChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	ms := make(proto.MessageSet, len(chunks))
+	for i := range chunks {
+		ms[i].Message = proto.Message{
+			Key:   key,
+			Value: chunks[i].Marshal(),
+		}
+	}
+	return ms, nil
+}
+
+// chunkedRecord reports a record sent in chunks once all of its chunks are
+// completed.
+type chunkedRecord struct {
+	p        *P
+	record   *Record
+	first    *Record // the first chunk, whose offset is reported
+	callback func(*Record)
+	pending  int
+	aborted  bool // not all chunks are added, so nothing is reported
+	// the whole value is written once to Spool or DeadLetterTopic for all
+	// of its failed chunks
+	spooled      bool
+	deadLettered bool
+	mu           sync.Mutex
+}
+
+// sendChunks adds the chunks of a value to the partition. If a chunk cannot
+// be added, the error is returned and the chunks already added are still sent
+// but not reported.
+func (p *P) sendChunks(topic string, partition int32, key, value []byte, callback func(*Record)) error {
+	chunks, err := proto.SplitChunks(value, p.ChunkSize)
+	if err != nil {
+		return err
+	}
+	c := &chunkedRecord{
+		p: p,
+		record: &Record{
+			Topic:     topic,
+			Partition: partition,
+			Key:       key,
+			Value:     value,
+		},
+		callback: callback,
+		// released after all chunks are added
+		pending: len(chunks) + 1,
+	}
+	for i := range chunks {
+		r := &Record{
+			Topic:      topic,
+			Partition:  partition,
+			Key:        key,
+			Value:      chunks[i].Marshal(),
+			callback:   c.done,
+			unreported: true,
+			parent:     c,
+		}
+		if i == 0 {
+			c.first = r
+		}
+		if err := p.acc.add(r); err != nil {
+			c.mu.Lock()
+			c.aborted = true
+			c.mu.Unlock()
+			c.release(len(chunks) - i + 1)
+			return err
+		}
+	}
+	c.release(1)
+	return nil
+}
+
+// spool writes the whole value to the spool unless it is already written to
+// the spool or DeadLetterTopic, and returns true if it is spooled.
+func (c *chunkedRecord) spool(s *Spool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.spooled || c.deadLettered {
+		return c.spooled
+	}
+	r := c.record
+	if err := s.Append(r); err != nil {
+		log.Errorf("fail to spool a message of %d bytes for partition %d in %s: %v", len(r.Value), r.Partition, r.Topic, err)
+		return false
+	}
+	c.spooled = true
+	return true
+}
+
+// deadLetter writes the whole value to DeadLetterTopic unless it is already
+// written there or to the spool, and returns where it is written.
+func (c *chunkedRecord) deadLetter(err error, attempts int, now time.Time) (spooled, deadLettered bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.spooled && !c.deadLettered {
+		c.deadLettered = c.p.writeDeadLetter(c.record, err, attempts, now)
+	}
+	return c.spooled, c.deadLettered
+}
+
+func (c *chunkedRecord) done(r *Record) {
+	c.mu.Lock()
+	if c.record.Err == nil {
+		c.record.Err = r.Err
+	}
+	if r.Spooled {
+		c.record.Spooled = true
+	}
+	if r.DeadLettered {
+		c.record.DeadLettered = true
+	}
+	if r == c.first {
+		c.record.Offset = r.Offset
+	}
+	c.mu.Unlock()
+	c.release(1)
+}
+
+func (c *chunkedRecord) release(n int) {
+	c.mu.Lock()
+	c.pending -= n
+	report := c.pending == 0 && !c.aborted
+	c.mu.Unlock()
+	if !report {
+		return
+	}
+	r := c.record
+	if r.Spooled {
+		r.Err = nil
+	}
+	if r.Err != nil || r.Spooled {
+		r.Offset = -1
+	}
+	if c.callback != nil {
+		c.callback(r)
+	}
+	if c.p.Reports != nil {
+		c.p.Reports <- r
+	}
+}
@@ -0,0 +1,202 @@
+package producer
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"h12.io/kpax/proto"
+)
+
+func joinChunks(t *testing.T, messages []proto.Message) []byte {
+	var value []byte
+	for i := range messages {
+		var c proto.Chunk
+		if !c.Unmarshal(messages[i].Value) || c.Index != int32(i) || c.Count != int32(len(messages)) {
+			t.Fatalf("unexpected chunk %d", i)
+		}
+		value = append(value, c.Data...)
+	}
+	return value
+}
+
+func TestSendChunks(t *testing.T) {
+	t.Parallel()
+	b0, b1 := newFakeBroker(), newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b0, b1)
+	p := New(c)
+	p.ChunkSize = 10
+	p.MaxMessageSize = messageOverhead + 1 + proto.ChunkHeaderSize + 10
+	reports := make(chan *Record, 10)
+	p.Reports = reports
+	value := bytes.Repeat([]byte("v"), 35)
+	var res results
+	if err := p.SendWithPartition("a", 1, []byte("k"), value, res.add); err != nil {
+		t.Fatal(err)
+	}
+	p.Flush()
+	records := res.get()
+	if len(records) != 1 || len(reports) != 1 {
+		t.Fatalf("expect one record reported but got %d, %d", len(records), len(reports))
+	}
+	if r := records[0]; r.Err != nil || r.Offset != 0 || !bytes.Equal(r.Value, value) {
+		t.Fatalf("unexpected record %v", r)
+	}
+	m := b1.messages("a", 1)
+	if len(m) != 4 || !bytes.Equal(joinChunks(t, m), value) {
+		t.Fatalf("expect 4 chunks but got %d", len(m))
+	}
+	for i := range m {
+		if string(m[i].Key) != "k" {
+			t.Fatal("expect the key in each chunk")
+		}
+	}
+}
+
+func TestProduceChunks(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b)
+	p := New(c)
+	p.ChunkSize = 10
+	if _, err := p.Produce("a", nil, []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+	value := bytes.Repeat([]byte("v"), 25)
+	d, err := p.Produce("a", nil, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Offset != 1 {
+		t.Fatalf("expect offset 1 but got %d", d.Offset)
+	}
+	m := b.messages("a", 0)
+	if len(m) != 4 || string(m[0].Value) != "small" || !bytes.Equal(joinChunks(t, m[1:]), value) {
+		t.Fatalf("unexpected messages %v", m)
+	}
+}
+
+func TestChunkSizeLimit(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b)
+	p := New(c)
+	p.ChunkSize = 10
+	p.MaxMessageSize = 100
+	large := bytes.Repeat([]byte("v"), 1000)
+	// split into chunks that fit
+	if _, err := p.Produce("a", nil, large); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.ProduceWithPartition("a", 0, nil, large); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SendWithPartition("a", 0, nil, large, nil); err != nil {
+		t.Fatal(err)
+	}
+	p.Flush()
+	// never split, so checked as it is
+	ms := getMessageSet(nil, large)
+	if _, err := p.ProduceMessageSet("a", ms); err == nil {
+		t.Fatal("expect a message set with a large value rejected")
+	}
+	p.Idempotent = true
+	if _, err := p.ProduceMessageSet("a", ms); err == nil {
+		t.Fatal("expect an idempotent message set with a large value rejected")
+	}
+}
+
+func TestChunksDeadLetter(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	b.setError("a", 0, proto.ErrRequestTimedOut)
+	dlq := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b)
+	c.setTopic("dlq", dlq)
+	p := New(c)
+	p.ChunkSize = 10
+	p.BatchSize = 1 // one batch per chunk
+	p.Retries = 1
+	p.RetryBackoff = time.Millisecond
+	p.DeadLetterTopic = "dlq"
+	reports := make(chan *Record, 10)
+	p.Reports = reports
+	value := bytes.Repeat([]byte("v"), 35)
+	var res results
+	if err := p.SendWithPartition("a", 0, []byte("k"), value, res.add); err != nil {
+		t.Fatal(err)
+	}
+	p.Flush()
+	records := res.get()
+	if len(records) != 1 || len(reports) != 1 {
+		t.Fatalf("expect one record reported but got %d, %d", len(records), len(reports))
+	}
+	if r := records[0]; r.Err != proto.ErrRequestTimedOut || !r.DeadLettered || r.Offset != -1 {
+		t.Fatalf("expect the record dead-lettered but got %v", r)
+	}
+	// the whole value once instead of each chunk
+	m := dlq.messages("dlq", 0)
+	if len(m) != 1 {
+		t.Fatalf("expect 1 dead letter but got %d", len(m))
+	}
+	var d proto.DeadLetter
+	if err := d.Unmarshal(m[0].Value); err != nil {
+		t.Fatal(err)
+	}
+	if d.Topic != "a" || string(d.Key) != "k" || !bytes.Equal(d.Value, value) {
+		t.Fatalf("unexpected dead letter %v", d)
+	}
+}
+
+func TestChunksSpool(t *testing.T) {
+	t.Parallel()
+	dir := newSpoolDir(t)
+	defer os.RemoveAll(dir)
+	s, err := OpenSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", nil)
+	p := New(c)
+	p.ChunkSize = 10
+	p.BatchSize = 1
+	p.Retries = 0
+	p.StrictOrder = true
+	p.Spool = s
+	value := bytes.Repeat([]byte("v"), 35)
+	var res results
+	if err := p.SendWithPartition("a", 0, nil, value, res.add); err != nil {
+		t.Fatal(err)
+	}
+	p.Flush()
+	// the cluster recovers, but the next value is spooled behind the first
+	c.setTopic("a", b)
+	if err := p.SendWithPartition("a", 0, nil, value, res.add); err != nil {
+		t.Fatal(err)
+	}
+	p.Flush()
+	for _, r := range res.get() {
+		if r.Err != nil || !r.Spooled || r.Offset != -1 {
+			t.Fatalf("expect spooled but got %v", r)
+		}
+	}
+	if n := s.Backlog("a", 0); n != 2 {
+		t.Fatalf("expect 2 values spooled but got %d", n)
+	}
+	if n := len(b.messages("a", 0)); n != 0 {
+		t.Fatalf("expect no chunk sent before the spool but got %d", n)
+	}
+	for _, r := range replayAll(t, s) {
+		if !bytes.Equal(r.Value, value) {
+			t.Fatal("expect the whole value spooled")
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package producer
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrClosed = errors.New("producer is closed")
+
+// CloseError is returned by Close if some messages are not delivered before
+// the deadline.
+type CloseError struct {
+	Undelivered []*Record // never sent, reported with ErrClosed
+	InFlight    int       // sent but not acknowledged yet, reported later
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("producer closed with %d messages undelivered and %d in flight", len(e.Undelivered), e.InFlight)
+}
+
+// Close refuses new messages, stops the replay of Spool and sends the
+// buffered messages. The messages not sent within the timeout are reported
+// with ErrClosed and returned in a CloseError.
+// Finally the cluster is closed if it has a Close method. Spool is not
+// closed.
+func (p *P) Close(timeout time.Duration) error {
+	p.StopReplay()
+	undelivered, inFlight := p.acc.close(timeout)
+	if c, ok := p.Cluster.(interface {
+		Close()
+	}); ok {
+		c.Close()
+	}
+	if len(undelivered) > 0 || inFlight > 0 {
+		return &CloseError{Undelivered: undelivered, InFlight: inFlight}
+	}
+	return nil
+}
+
+func (p *P) closed() bool {
+	p.acc.mu.Lock()
+	defer p.acc.mu.Unlock()
+	return p.acc.closed
+}
+
+func (a *accumulator) close(timeout time.Duration) (undelivered []*Record, inFlight int) {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil, 0
+	}
+	a.closed = true
+	// wakes up add blocked on a full buffer to fail with ErrClosed
+	a.cond.Broadcast()
+	for _, b := range a.batches {
+		a.seal(b)
+	}
+	expired := false
+	timer := time.AfterFunc(timeout, func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		expired = true
+		a.cond.Broadcast()
+	})
+	for a.pending > 0 && !expired {
+		a.cond.Wait()
+	}
+	timer.Stop()
+	a.aborted = true
+	ready := a.ready
+	a.ready = nil
+	a.mu.Unlock()
+	close(a.quit)
+
+	for _, b := range ready {
+		a.report(b, -1, ErrClosed)
+		undelivered = append(undelivered, b.records...)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, b := range ready {
+		a.pending -= len(b.records)
+		a.buffered -= b.size
+	}
+	a.cond.Broadcast()
+	return undelivered, a.pending
+}
@@ -0,0 +1,63 @@
+package producer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCloseFlush(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b)
+	p := New(c)
+	p.Linger = time.Hour
+	for i := 0; i < 3; i++ {
+		if err := p.Send("a", nil, []byte("v"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(b.messages("a", 0)); n != 3 {
+		t.Fatalf("expect 3 messages but got %d", n)
+	}
+	if !c.isClosed() {
+		t.Fatal("expect the cluster closed")
+	}
+	if err := p.Send("a", nil, []byte("v"), nil); err != ErrClosed {
+		t.Fatalf("expect ErrClosed but got %v", err)
+	}
+	if _, err := p.Produce("a", nil, []byte("v")); err != ErrClosed {
+		t.Fatalf("expect ErrClosed but got %v", err)
+	}
+	if err := p.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCloseDeadline(t *testing.T) {
+	t.Parallel()
+	c := newFakeCluster()
+	c.setTopic("a", nil)
+	p := New(c)
+	p.Linger = 0
+	p.StrictOrder = true
+	p.RetryBackoff = time.Hour
+	var res results
+	// the first batch waits for a retry and the second one is never sent
+	p.SendWithPartition("a", 0, nil, []byte("0"), res.add)
+	p.SendWithPartition("a", 0, nil, []byte("1"), res.add)
+	err := p.Close(20 * time.Millisecond)
+	ce, ok := err.(*CloseError)
+	if !ok {
+		t.Fatalf("expect CloseError but got %v", err)
+	}
+	if len(ce.Undelivered) != 1 || string(ce.Undelivered[0].Value) != "1" || ce.InFlight != 1 {
+		t.Fatalf("unexpected close error %v", ce)
+	}
+	if rs := res.get(); len(rs) != 1 || rs[0].Err != ErrClosed {
+		t.Fatalf("expect the undelivered message reported with ErrClosed but got %v", rs)
+	}
+}
@@ -0,0 +1,73 @@
+package producer
+
+import (
+	"time"
+
+	"h12.io/kpax/log"
+	"h12.io/kpax/proto"
+)
+
+// deadLetter writes the records of a batch failed to be sent to
+// DeadLetterTopic if any, except the ones of a synchronous Produce, whose
+// error is returned to the caller instead. The records dropped by the
+// producer itself on ErrClosed or ErrBufferFull are not written either.
+func (a *accumulator) deadLetter(b *batch, err error) {
+	p := a.p
+	if err == nil || err == ErrClosed || err == ErrBufferFull || p.DeadLetterTopic == "" || b.topic == p.DeadLetterTopic {
+		return
+	}
+	now := time.Now()
+	for _, r := range b.records {
+		switch {
+		case r.sync || r.Spooled:
+		case r.parent != nil:
+			r.Spooled, r.DeadLettered = r.parent.deadLetter(err, b.attempts+1, now)
+		default:
+			r.DeadLettered = p.writeDeadLetter(r, err, b.attempts+1, now)
+		}
+	}
+}
+
+// writeDeadLetter writes a record failed to be sent to DeadLetterTopic and
+// returns true on success.
+func (p *P) writeDeadLetter(r *Record, err error, attempts int, now time.Time) bool {
+	if err := p.produceDeadLetter(&proto.DeadLetter{
+		Topic:     r.Topic,
+		Partition: r.Partition,
+		Offset:    -1,
+		Key:       r.Key,
+		Value:     r.Value,
+		Error:     err.Error(),
+		Attempts:  attempts,
+		Time:      now,
+	}); err != nil {
+		log.Errorf("fail to write a message for partition %d in %s to %s: %v", r.Partition, r.Topic, p.DeadLetterTopic, err)
+		return false
+	}
+	return true
+}
+
+// produceDeadLetter writes to DeadLetterTopic synchronously, so that it is
+// done before the failed record is reported. It is not idempotent and works
+// even when the producer is closing.
+func (p *P) produceDeadLetter(d *proto.DeadLetter) error {
+	topic := p.DeadLetterTopic
+	partitions, err := p.Cluster.Partitions(topic)
+	if err != nil {
+		return err
+	}
+	partition, err := p.partitioner(topic).Partition(d.Key, partitions)
+	if err != nil {
+		return err
+	}
+	messageSet := getMessageSet(d.Key, d.Marshal())
+	p.waitMessageSet(topic, messageSet)
+	config := p.topicSettings(topic)
+	return p.produce(&proto.Payload{
+		Topic:        topic,
+		Partition:    partition,
+		MessageSet:   messageSet.Compress(config.Compression),
+		RequiredAcks: config.RequiredAcks,
+		AckTimeout:   config.AckTimeout,
+	})
+}
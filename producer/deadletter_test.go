@@ -0,0 +1,51 @@
+package producer
+
+import (
+	"testing"
+	"time"
+
+	"h12.io/kpax/proto"
+)
+
+func TestDeadLetter(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	b.setError("a", 0, proto.ErrRequestTimedOut)
+	dlq := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b)
+	c.setTopic("dlq", dlq)
+	p := New(c)
+	p.Retries = 1
+	p.RetryBackoff = time.Millisecond
+	p.DeadLetterTopic = "dlq"
+	var res results
+	if err := p.Send("a", []byte("k"), []byte("v"), res.add); err != nil {
+		t.Fatal(err)
+	}
+	p.Flush()
+	records := res.get()
+	if len(records) != 1 || records[0].Err != proto.ErrRequestTimedOut || !records[0].DeadLettered {
+		t.Fatalf("expect the record dead-lettered but got %v", records)
+	}
+	m := dlq.messages("dlq", 0)
+	if len(m) != 1 {
+		t.Fatalf("expect 1 dead letter but got %d", len(m))
+	}
+	var d proto.DeadLetter
+	if err := d.Unmarshal(m[0].Value); err != nil {
+		t.Fatal(err)
+	}
+	if d.Topic != "a" || d.Partition != 0 || d.Offset != -1 || string(d.Key) != "k" || string(d.Value) != "v" ||
+		d.Error != proto.ErrRequestTimedOut.Error() || d.Attempts != 2 {
+		t.Fatalf("unexpected dead letter %v", d)
+	}
+
+	// the error of a synchronous Produce is returned instead
+	if _, err := p.Produce("a", nil, []byte("v")); err != proto.ErrRequestTimedOut {
+		t.Fatalf("expect ErrRequestTimedOut but got %v", err)
+	}
+	if n := len(dlq.messages("dlq", 0)); n != 1 {
+		t.Fatalf("expect 1 dead letter but got %d", n)
+	}
+}
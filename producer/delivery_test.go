@@ -0,0 +1,73 @@
+package producer
+
+import (
+	"testing"
+	"time"
+
+	"h12.io/kpax/proto"
+)
+
+func TestProduceDelivery(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b, b)
+	p := New(c)
+	for i := 0; i < 3; i++ {
+		d, err := p.ProduceWithPartition("a", 1, nil, []byte("v"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d != (Delivery{Topic: "a", Partition: 1, Offset: int64(i)}) {
+			t.Fatalf("unexpected delivery %v", d)
+		}
+	}
+	d, err := p.Produce("a", []byte("k"), []byte("v"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := toPositive(murmur2([]byte("k"))) % 2
+	offset := int64(0)
+	if expected == 1 {
+		offset = 3
+	}
+	if d != (Delivery{Topic: "a", Partition: expected, Offset: offset}) {
+		t.Fatalf("unexpected delivery %v", d)
+	}
+}
+
+func TestSendReports(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	b.setError("a", 1, proto.ErrNotLeaderForPartition)
+	c := newFakeCluster()
+	c.setTopic("a", b, b)
+	reports := make(chan *Record, 10)
+	p := New(c)
+	p.Linger = time.Hour
+	p.Reports = reports
+	p.Retries = 0
+	for i := 0; i < 3; i++ {
+		p.SendWithPartition("a", 0, nil, []byte("v"), nil)
+	}
+	p.SendWithPartition("a", 1, nil, []byte("v"), nil)
+	p.Flush()
+	close(reports)
+	offsets := make(map[int64]bool)
+	for r := range reports {
+		switch r.Partition {
+		case 0:
+			if r.Err != nil {
+				t.Fatal(r.Err)
+			}
+			offsets[r.Offset] = true
+		case 1:
+			if r.Err != proto.ErrNotLeaderForPartition || r.Offset != -1 {
+				t.Fatalf("unexpected report %v", r)
+			}
+		}
+	}
+	if len(offsets) != 3 || !offsets[0] || !offsets[1] || !offsets[2] {
+		t.Fatalf("expect offsets 0, 1, 2 but got %v", offsets)
+	}
+}
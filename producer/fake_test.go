@@ -0,0 +1,402 @@
+package producer
+
+import (
+	"errors"
+	"sync"
+
+	"h12.io/kpax/model"
+	"h12.io/kpax/proto"
+	"h12.io/wipro"
+)
+
+var (
+	errNoLeader   = errors.New("fake cluster: no leader")
+	errBrokerDown = errors.New("fake broker is down")
+)
+
+// fakeCluster has topics with partitions led by in-memory brokers
+type fakeCluster struct {
+	leaders     map[string][]*fakeBroker
+	coordinator *fakeBroker // coordinates both groups and transactions
+	leaderDown  int
+	closed      bool
+	mu          sync.Mutex
+}
+
+func newFakeCluster() *fakeCluster {
+	return &fakeCluster{leaders: make(map[string][]*fakeBroker)}
+}
+
+// setTopic sets the leaders of the topic, one partition per leader
+func (c *fakeCluster) setTopic(topic string, leaders ...*fakeBroker) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.leaders[topic] = leaders
+}
+
+func (c *fakeCluster) Leader(topic string, partition int32) (model.Broker, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	leaders := c.leaders[topic]
+	if int(partition) >= len(leaders) || leaders[partition] == nil {
+		return nil, errNoLeader
+	}
+	return leaders[partition], nil
+}
+
+func (c *fakeCluster) LeaderIsDown(topic string, partition int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.leaderDown++
+}
+
+func (c *fakeCluster) leaderDownCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.leaderDown
+}
+
+func (c *fakeCluster) Partitions(topic string) ([]int32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	leaders, ok := c.leaders[topic]
+	if !ok {
+		return nil, proto.ErrUnknownTopicOrPartition
+	}
+	partitions := make([]int32, len(leaders))
+	for i := range partitions {
+		partitions[i] = int32(i)
+	}
+	return partitions, nil
+}
+
+func (c *fakeCluster) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+}
+
+func (c *fakeCluster) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *fakeCluster) setCoordinator(b *fakeBroker) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.coordinator = b
+}
+
+func (c *fakeCluster) Coordinator(group string) (model.Broker, error) {
+	return c.TransactionCoordinator(group)
+}
+
+func (c *fakeCluster) TransactionCoordinator(transactionalID string) (model.Broker, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.coordinator == nil {
+		return nil, errNoLeader
+	}
+	return c.coordinator, nil
+}
+
+func (c *fakeCluster) CoordinatorIsDown(group string)                             {}
+func (c *fakeCluster) TransactionCoordinatorIsDown(transactionalID string)        {}
+func (c *fakeCluster) Controller() (model.Broker, error)                          { return nil, errNoLeader }
+func (c *fakeCluster) ControllerIsDown()                                          {}
+func (c *fakeCluster) PartitionInfos(topic string) ([]model.PartitionInfo, error) { return nil, nil }
+func (c *fakeCluster) Topics() ([]string, error)                                  { return nil, nil }
+func (c *fakeCluster) Brokers() ([]model.BrokerInfo, error)                       { return nil, nil }
+
+// fakeBroker appends produced messages to in-memory partition logs, and
+// checks the sequence numbers of record batches like a Kafka 0.11 broker
+type fakeBroker struct {
+	logs       map[topicPartition][]proto.Message
+	errs       map[topicPartition]proto.ErrorCode
+	failures   map[topicPartition][]proto.ErrorCode
+	producers  map[producerKey]*producerState
+	lostAcks   int
+	nextPID    int64
+	inits      int
+	requests   int
+	acks       []int16 // of each produce request
+	compressed int     // batches or messages received compressed
+	maxBatch   int     // a batch of more messages is rejected as too large
+	txn        fakeTxn
+	mu         sync.Mutex
+}
+
+// fakeTxn records the transactional requests to a coordinator and the
+// transactional batches to a leader
+type fakeTxn struct {
+	transactionalID string
+	partitions      []topicPartition
+	groups          []string
+	offsets         []proto.TxnOffsetInTopic
+	ends            []bool // committed or aborted
+	batches         int
+}
+
+type producerKey struct {
+	topicPartition
+	pid int64
+}
+
+type producerState struct {
+	epoch       int16
+	lastBaseSeq int32
+	lastSeq     int32
+}
+
+func newFakeBroker() *fakeBroker {
+	return &fakeBroker{
+		logs:      make(map[topicPartition][]proto.Message),
+		errs:      make(map[topicPartition]proto.ErrorCode),
+		failures:  make(map[topicPartition][]proto.ErrorCode),
+		producers: make(map[producerKey]*producerState),
+		nextPID:   1000,
+	}
+}
+
+func (b *fakeBroker) setError(topic string, partition int32, code proto.ErrorCode) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.errs[topicPartition{topic, partition}] = code
+}
+
+// fail makes the next requests to the partition fail with the codes in order
+func (b *fakeBroker) fail(topic string, partition int32, codes ...proto.ErrorCode) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	tp := topicPartition{topic, partition}
+	b.failures[tp] = append(b.failures[tp], codes...)
+}
+
+// loseAcks makes the next n produce requests written but not acknowledged
+func (b *fakeBroker) loseAcks(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lostAcks += n
+}
+
+// forgetProducers drops the producer states like an expiration
+func (b *fakeBroker) forgetProducers() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.producers = make(map[producerKey]*producerState)
+}
+
+func (b *fakeBroker) messages(topic string, partition int32) []proto.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]proto.Message(nil), b.logs[topicPartition{topic, partition}]...)
+}
+
+func (b *fakeBroker) requestCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.requests
+}
+
+func (b *fakeBroker) setMaxBatch(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.maxBatch = n
+}
+
+func (b *fakeBroker) requiredAcks() []int16 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]int16(nil), b.acks...)
+}
+
+func (b *fakeBroker) compressedCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.compressed
+}
+
+func (b *fakeBroker) initCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.inits
+}
+
+func (b *fakeBroker) txnState() fakeTxn {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.txn
+}
+
+func (b *fakeBroker) Do(req model.Request, resp model.Response) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch r := req.(*proto.Request).RequestMessage.(type) {
+	case *proto.ProduceRequest:
+		b.requests++
+		b.acks = append(b.acks, r.RequiredAcks)
+		m := b.produce(r)
+		if pm, ok := resp.(*proto.Response).ResponseMessage.(*proto.ProduceResponse); ok {
+			*pm = m
+		}
+	case *proto.ProduceRequestV3:
+		b.requests++
+		b.acks = append(b.acks, r.RequiredAcks)
+		// decode from the wire format
+		var w wipro.Writer
+		r.Marshal(&w)
+		var decoded proto.ProduceRequestV3
+		rd := &wipro.Reader{B: w.B}
+		decoded.Unmarshal(rd)
+		if rd.Err != nil {
+			return rd.Err
+		}
+		m := b.produceV3(&decoded)
+		if b.lostAcks > 0 {
+			b.lostAcks--
+			return errBrokerDown
+		}
+		*resp.(*proto.Response).ResponseMessage.(*proto.ProduceResponseV3) = m
+	case *proto.InitProducerIDRequest:
+		b.inits++
+		b.txn.transactionalID = string(r.TransactionalID)
+		m := resp.(*proto.Response).ResponseMessage.(*proto.InitProducerIDResponse)
+		m.ProducerID = b.nextPID
+		b.nextPID++
+	case *proto.AddPartitionsToTxnRequest:
+		m := resp.(*proto.Response).ResponseMessage.(*proto.AddPartitionsToTxnResponse)
+		for _, t := range r.PartitionInTopics {
+			et := proto.ErrorInTopic{TopicName: t.TopicName}
+			for _, partition := range t.Partitions {
+				b.txn.partitions = append(b.txn.partitions, topicPartition{t.TopicName, partition})
+				et.ErrorInPartitions = append(et.ErrorInPartitions, proto.ErrorInPartition{Partition: partition})
+			}
+			m.ErrorInTopics = append(m.ErrorInTopics, et)
+		}
+	case *proto.AddOffsetsToTxnRequest:
+		b.txn.groups = append(b.txn.groups, r.GroupID)
+	case *proto.TxnOffsetCommitRequest:
+		b.txn.offsets = append(b.txn.offsets, r.TxnOffsetInTopics...)
+	case *proto.EndTxnRequest:
+		b.txn.ends = append(b.txn.ends, r.Committed == 1)
+	default:
+		return errors.New("fake broker: unsupported request")
+	}
+	return nil
+}
+
+// injectedError returns the error set by setError or fail
+func (b *fakeBroker) injectedError(tp topicPartition) proto.ErrorCode {
+	if code, ok := b.errs[tp]; ok {
+		return code
+	}
+	if codes := b.failures[tp]; len(codes) > 0 {
+		b.failures[tp] = codes[1:]
+		return codes[0]
+	}
+	return proto.NoError
+}
+
+func (b *fakeBroker) produce(r *proto.ProduceRequest) proto.ProduceResponse {
+	var m proto.ProduceResponse
+	for _, t := range r.MessageSetInTopics {
+		ot := proto.OffsetInTopic{TopicName: t.TopicName}
+		for _, p := range t.MessageSetInPartitions {
+			tp := topicPartition{t.TopicName, p.Partition}
+			op := proto.OffsetInPartition{Partition: p.Partition, Offset: -1}
+			if op.ErrorCode = b.injectedError(tp); op.ErrorCode == proto.NoError {
+				var messages []proto.Message
+				for i := range p.MessageSet {
+					m := p.MessageSet[i].Message
+					if !m.Compressed() {
+						messages = append(messages, m)
+						continue
+					}
+					b.compressed++
+					ms, err := m.Decompress()
+					if err != nil {
+						op.ErrorCode = proto.ErrInvalidMessage
+						break
+					}
+					for j := range ms {
+						messages = append(messages, ms[j].Message)
+					}
+				}
+				if b.maxBatch > 0 && len(messages) > b.maxBatch {
+					op.ErrorCode = proto.ErrMessageSizeTooLarge
+				}
+				if op.ErrorCode == proto.NoError {
+					op.Offset = int64(len(b.logs[tp]))
+					b.logs[tp] = append(b.logs[tp], messages...)
+				}
+			}
+			ot.OffsetInPartitions = append(ot.OffsetInPartitions, op)
+		}
+		m = append(m, ot)
+	}
+	return m
+}
+
+func (b *fakeBroker) produceV3(r *proto.ProduceRequestV3) proto.ProduceResponseV3 {
+	var m proto.ProduceResponseV3
+	for _, t := range r.RecordSetInTopics {
+		ot := proto.OffsetInTopicV3{TopicName: t.TopicName}
+		for _, p := range t.RecordSetInPartitions {
+			tp := topicPartition{t.TopicName, p.Partition}
+			op := proto.OffsetInPartitionV3{Partition: p.Partition, Offset: -1}
+			if op.ErrorCode = b.injectedError(tp); op.ErrorCode == proto.NoError {
+				for i := range p.RecordSet {
+					if b.maxBatch > 0 && len(p.RecordSet[i].Records) > b.maxBatch {
+						op.ErrorCode = proto.ErrRecordListTooLargeCode
+						break
+					}
+					if op.ErrorCode = b.checkSequence(tp, &p.RecordSet[i]); op.ErrorCode != proto.NoError {
+						break
+					}
+					if p.RecordSet[i].Transactional() {
+						b.txn.batches++
+					}
+					if p.RecordSet[i].Attributes&int16(proto.SnappyCompression) != 0 {
+						b.compressed++
+					}
+					if i == 0 {
+						op.Offset = int64(len(b.logs[tp]))
+					}
+					for _, record := range p.RecordSet[i].Records {
+						b.logs[tp] = append(b.logs[tp], proto.Message{Key: record.Key, Value: record.Value})
+					}
+				}
+			}
+			ot.OffsetInPartitionV3s = append(ot.OffsetInPartitionV3s, op)
+		}
+		m.OffsetInTopicV3s = append(m.OffsetInTopicV3s, ot)
+	}
+	return m
+}
+
+func (b *fakeBroker) checkSequence(tp topicPartition, batch *proto.RecordBatch) proto.ErrorCode {
+	if batch.ProducerID == proto.NoProducerID {
+		return proto.NoError
+	}
+	key := producerKey{tp, batch.ProducerID}
+	last := batch.BaseSequence + batch.LastOffsetDelta
+	st, ok := b.producers[key]
+	switch {
+	case !ok && batch.BaseSequence != 0:
+		return proto.ErrUnknownProducerIDCode
+	case !ok:
+		b.producers[key] = &producerState{epoch: batch.ProducerEpoch, lastBaseSeq: batch.BaseSequence, lastSeq: last}
+		return proto.NoError
+	case batch.ProducerEpoch < st.epoch:
+		return proto.ErrInvalidProducerEpochCode
+	case batch.BaseSequence == st.lastBaseSeq && last == st.lastSeq:
+		return proto.ErrDuplicateSequenceNumberCode
+	case batch.BaseSequence != st.lastSeq+1:
+		return proto.ErrOutOfOrderSequenceNumberCode
+	}
+	st.lastBaseSeq, st.lastSeq = batch.BaseSequence, last
+	return proto.NoError
+}
+
+func (b *fakeBroker) Close() {}
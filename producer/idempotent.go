@@ -0,0 +1,203 @@
+package producer
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"h12.io/kpax/model"
+	"h12.io/kpax/proto"
+)
+
+// idempotence holds the producer ID and the next sequence number of each
+// partition. The fields except initMu are guarded by the accumulator lock.
+type idempotence struct {
+	pid        proto.ProducerID
+	valid      bool
+	generation int // incremented each time a producer ID is obtained
+	sequences  map[topicPartition]int32
+	initMu     sync.Mutex
+}
+
+// initProducerID obtains a producer ID from the broker if there is no valid
+// one. A transactional producer ID is obtained from the transaction
+// coordinator instead.
+func (a *accumulator) initProducerID(b model.Broker) error {
+	a.idem.initMu.Lock()
+	defer a.idem.initMu.Unlock()
+	a.mu.Lock()
+	valid := a.idem.valid
+	a.mu.Unlock()
+	if valid {
+		return nil
+	}
+	pid := proto.ProducerID{
+		TransactionalID:    a.p.TransactionalID,
+		TransactionTimeout: a.p.TransactionTimeout,
+	}
+	var err error
+	if pid.TransactionalID != "" {
+		err = a.p.doTxn(pid.DoInit)
+	} else {
+		err = pid.DoInit(b)
+	}
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.idem.pid = pid
+	a.idem.valid = true
+	a.idem.generation++
+	a.idem.sequences = make(map[topicPartition]int32)
+	return nil
+}
+
+func (a *accumulator) producerID() proto.ProducerID {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.idem.pid
+}
+
+// resetProducerID invalidates the producer ID if it is still the one of the
+// given generation, so that a new one is obtained with the sequence numbers
+// starting from 0.
+func (a *accumulator) resetProducerID(generation int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.idem.valid && a.idem.generation == generation {
+		a.idem.valid = false
+	}
+}
+
+// assignSequence assigns the producer ID and sequence numbers to a batch the
+// first time it is sent with the current producer ID. A retried batch keeps
+// its sequence numbers so that the broker can detect a duplicate. It must be
+// called with the lock held.
+func (a *accumulator) assignSequence(b *batch) {
+	if b.generation == a.idem.generation {
+		return
+	}
+	b.pid = a.idem.pid
+	b.generation = a.idem.generation
+	b.sequence = a.idem.sequences[b.topicPartition]
+	a.idem.sequences[b.topicPartition] = nextSequence(b.sequence, len(b.records))
+}
+
+// nextSequence wraps around to 0 after math.MaxInt32 like the Java client.
+func nextSequence(sequence int32, n int) int32 {
+	next := int64(sequence) + int64(n)
+	if next > math.MaxInt32 {
+		next -= math.MaxInt32 + 1
+	}
+	return int32(next)
+}
+
+func (b *batch) recordBatch() proto.RecordBatch {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	records := make([]proto.Record, len(b.records))
+	for i, r := range b.records {
+		records[i] = proto.Record{
+			OffsetDelta: int32(i),
+			Key:         r.Key,
+			Value:       r.Value,
+		}
+	}
+	return proto.RecordBatch{
+		LastOffsetDelta: int32(len(records) - 1),
+		FirstTimestamp:  now,
+		MaxTimestamp:    now,
+		ProducerID:      b.pid.ID,
+		ProducerEpoch:   b.pid.Epoch,
+		BaseSequence:    b.sequence,
+		Records:         records,
+	}
+}
+
+// produceRecords sends the batches as record batches with the producer ID and
+// sequence numbers, so that the broker can discard a duplicate caused by a
+// retry.
+func (a *accumulator) produceRecords(leader model.Broker, batches []*batch) {
+	if err := a.initProducerID(leader); err != nil {
+		for _, b := range batches {
+			a.fail(b, err)
+		}
+		return
+	}
+	a.mu.Lock()
+	for _, b := range batches {
+		a.assignSequence(b)
+	}
+	a.mu.Unlock()
+	payloads := make(proto.RecordPayloads, len(batches))
+	for i, b := range batches {
+		rb := b.recordBatch()
+		rb.Attributes |= int16(b.config.Compression)
+		if a.p.TransactionalID != "" {
+			rb.Attributes |= proto.TransactionalBatch
+		}
+		payloads[i] = &proto.RecordPayload{
+			Topic:           b.topic,
+			Partition:       b.partition,
+			RecordBatch:     rb,
+			RequiredAcks:    proto.AckAll,
+			AckTimeout:      b.config.AckTimeout,
+			TransactionalID: a.p.TransactionalID,
+		}
+	}
+	errs := payloads.DoProduce(leader)
+	for i, b := range batches {
+		switch err := errs[i]; err {
+		case nil:
+			a.complete(b, payloads[i].Offset, nil)
+		case proto.ErrDuplicateSequenceNumberCode:
+			// already written by a previous attempt
+			a.complete(b, -1, nil)
+		case proto.ErrOutOfOrderSequenceNumberCode, proto.ErrUnknownProducerIDCode:
+			if a.p.TransactionalID != "" {
+				// a new producer ID would abort the transaction
+				a.complete(b, -1, err)
+				continue
+			}
+			// the broker lost the producer state, start over with a new
+			// producer ID
+			a.resetProducerID(b.generation)
+			a.retry(b, err)
+		default:
+			a.failOnLeader(b, err)
+		}
+	}
+}
+
+// produceIdempotent sends the message set with the accumulator without
+// lingering and waits for the result.
+func (p *P) produceIdempotent(topic string, partition int32, messageSet proto.MessageSet) (Delivery, error) {
+	var wg sync.WaitGroup
+	records := make([]*Record, len(messageSet))
+	for i := range messageSet {
+		m := &messageSet[i].Message
+		records[i] = &Record{
+			Topic:     topic,
+			Partition: partition,
+			Key:       m.Key,
+			Value:     m.Value,
+			callback:  func(*Record) { wg.Done() },
+			sync:      true,
+		}
+	}
+	wg.Add(len(records))
+	for _, r := range records {
+		if err := p.acc.add(r); err != nil {
+			r.Err = err
+			wg.Done()
+		}
+	}
+	p.acc.sealPartition(topicPartition{topic, partition})
+	wg.Wait()
+	for _, r := range records {
+		if r.Err != nil {
+			return Delivery{}, r.Err
+		}
+	}
+	return Delivery{Topic: topic, Partition: partition, Offset: records[0].Offset}, nil
+}
@@ -0,0 +1,126 @@
+package producer
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"h12.io/kpax/proto"
+)
+
+func newIdempotentProducer(c *fakeCluster) *P {
+	p := New(c)
+	p.Idempotent = true
+	p.Linger = time.Hour
+	p.RetryBackoff = time.Millisecond
+	return p
+}
+
+func TestIdempotentLostAck(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b)
+	p := newIdempotentProducer(c)
+	if _, err := p.ProduceWithPartition("a", 0, nil, []byte("0")); err != nil {
+		t.Fatal(err)
+	}
+	// the batch is written but the response is lost, so it is retried
+	b.loseAcks(1)
+	var res results
+	for i := 1; i <= 3; i++ {
+		p.SendWithPartition("a", 0, nil, []byte(strconv.Itoa(i)), res.add)
+	}
+	p.Flush()
+	for _, r := range res.get() {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+	}
+	ms := b.messages("a", 0)
+	if len(ms) != 4 {
+		t.Fatalf("expect 4 messages without duplicates but got %d", len(ms))
+	}
+	for i := range ms {
+		if string(ms[i].Value) != strconv.Itoa(i) {
+			t.Fatalf("expect message %d but got %s", i, ms[i].Value)
+		}
+	}
+	if n := b.initCount(); n != 1 {
+		t.Fatalf("expect one producer ID but got %d", n)
+	}
+}
+
+func TestIdempotentUnknownProducerID(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b)
+	p := newIdempotentProducer(c)
+	if _, err := p.Produce("a", []byte("k"), []byte("0")); err != nil {
+		t.Fatal(err)
+	}
+	b.forgetProducers()
+	d, err := p.Produce("a", []byte("k"), []byte("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Offset != 1 {
+		t.Fatalf("expect offset 1 but got %d", d.Offset)
+	}
+	if n := b.initCount(); n != 2 {
+		t.Fatalf("expect a new producer ID but got %d inits", n)
+	}
+}
+
+func TestIdempotentOutOfOrderSequence(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b, b)
+	p := newIdempotentProducer(c)
+	b.fail("a", 1, proto.ErrOutOfOrderSequenceNumberCode)
+	var res results
+	for i := 0; i < 3; i++ {
+		p.SendWithPartition("a", int32(i%2), nil, []byte(strconv.Itoa(i)), res.add)
+	}
+	p.Flush()
+	for _, r := range res.get() {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+	}
+	if len(b.messages("a", 0)) != 2 || len(b.messages("a", 1)) != 1 {
+		t.Fatalf("unexpected logs %v, %v", b.messages("a", 0), b.messages("a", 1))
+	}
+	// sequences of partition 0 are reset too
+	if _, err := p.ProduceWithPartition("a", 0, nil, []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIdempotentFenced(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b)
+	b.setError("a", 0, proto.ErrInvalidProducerEpochCode)
+	p := newIdempotentProducer(c)
+	if _, err := p.ProduceWithPartition("a", 0, nil, []byte("v")); err != proto.ErrInvalidProducerEpochCode {
+		t.Fatalf("expect ErrInvalidProducerEpochCode but got %v", err)
+	}
+	if n := b.requestCount(); n != 1 {
+		t.Fatalf("a fenced producer should not retry, got %d requests", n)
+	}
+}
+
+func TestNextSequence(t *testing.T) {
+	t.Parallel()
+	if seq := nextSequence(5, 3); seq != 8 {
+		t.Fatalf("expect 8 but got %d", seq)
+	}
+	if seq := nextSequence(math.MaxInt32-1, 3); seq != 1 {
+		t.Fatalf("expect 1 but got %d", seq)
+	}
+}
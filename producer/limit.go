@@ -0,0 +1,38 @@
+package producer
+
+import (
+	"h12.io/kpax/proto"
+)
+
+// wait blocks until Limiter allows the messages and bytes of keys and values
+// to the topic.
+func (p *P) wait(topic string, messages, bytes int) {
+	if p.Limiter != nil {
+		p.Limiter.Wait(topic, messages, bytes)
+	}
+}
+
+func (p *P) waitMessageSet(topic string, messageSet proto.MessageSet) {
+	bytes := 0
+	for i := range messageSet {
+		m := &messageSet[i].Message
+		bytes += len(m.Key) + len(m.Value)
+	}
+	p.wait(topic, len(messageSet), bytes)
+}
+
+// waitBatches waits for the batches sent for the first time. A retry or a
+// split half is not charged again.
+func (a *accumulator) waitBatches(batches []*batch) {
+	for _, b := range batches {
+		if b.charged {
+			continue
+		}
+		b.charged = true
+		bytes := 0
+		for _, r := range b.records {
+			bytes += len(r.Key) + len(r.Value)
+		}
+		a.p.wait(b.topic, len(b.records), bytes)
+	}
+}
@@ -0,0 +1,51 @@
+package producer
+
+import (
+	"testing"
+	"time"
+
+	"h12.io/kpax/ratelimit"
+)
+
+func TestLimiter(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b)
+	c.setTopic("b", b)
+	p := New(c)
+	p.Linger = 0
+	p.Limiter = ratelimit.New(ratelimit.Rate{})
+	p.Limiter.SetTopicRate("a", ratelimit.Rate{Messages: 20})
+
+	start := time.Now()
+	for i := 0; i < 30; i++ {
+		if err := p.Send("b", nil, []byte("v"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Flush()
+	if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+		t.Fatalf("expect b unlimited but took %v", elapsed)
+	}
+
+	start = time.Now()
+	for i := 0; i < 20; i++ {
+		if err := p.Send("a", nil, []byte("v"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := p.Produce("a", nil, []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Flush()
+	// 20 messages of burst and 10 more at 20 per second
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("expect a limited but took %v", elapsed)
+	}
+	if n := len(b.messages("a", 0)); n != 30 {
+		t.Fatalf("expect 30 messages but got %d", n)
+	}
+}
@@ -0,0 +1,66 @@
+package producer
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"h12.io/kpax/proto"
+)
+
+func TestSendStrictOrder(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b)
+	b.fail("a", 0, proto.ErrNotLeaderForPartition, proto.ErrNotLeaderForPartition)
+	p := New(c)
+	p.StrictOrder = true
+	p.Linger = time.Hour
+	p.BatchSize = 1 // one message per batch
+	p.RetryBackoff = 10 * time.Millisecond
+	var res results
+	for i := 0; i < 5; i++ {
+		p.Send("a", []byte("k"), []byte(strconv.Itoa(i)), res.add)
+	}
+	p.Flush()
+	for _, r := range res.get() {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+	}
+	ms := b.messages("a", 0)
+	if len(ms) != 5 {
+		t.Fatalf("expect 5 messages but got %d", len(ms))
+	}
+	for i := range ms {
+		if string(ms[i].Value) != strconv.Itoa(i) {
+			t.Fatalf("expect message %d at offset %d but got %s", i, i, ms[i].Value)
+		}
+	}
+}
+
+func TestTakeReadyOncePerPartition(t *testing.T) {
+	t.Parallel()
+	a := newAccumulator(New(newFakeCluster()))
+	a0 := &batch{topicPartition: topicPartition{"a", 0}}
+	a0b := &batch{topicPartition: topicPartition{"a", 0}}
+	a1 := &batch{topicPartition: topicPartition{"a", 1}}
+	a.ready = []*batch{a0, a0b, a1}
+	if batches := a.takeReady(); len(batches) != 2 || batches[0] != a0 || batches[1] != a1 {
+		t.Fatalf("unexpected batches %v", batches)
+	}
+	if batches := a.takeReady(); len(batches) != 1 || batches[0] != a0b {
+		t.Fatalf("unexpected batches %v", batches)
+	}
+
+	a.p.StrictOrder = true
+	a.ready = []*batch{a0b, a1}
+	if batches := a.takeReady(); len(batches) != 0 {
+		t.Fatalf("batches in flight should not be taken, got %v", batches)
+	}
+	a.requeue(a0)
+	if batches := a.takeReady(); len(batches) != 1 || batches[0] != a0 {
+		t.Fatalf("the retried batch should be taken first, got %v", batches)
+	}
+}
@@ -1,67 +1,234 @@
 package producer
 
-import "sync"
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var ErrManualPartition = errors.New("partition must be specified explicitly with the manual partitioner")
+
+// Partitioner chooses a partition for a message among the partitions of a
+// topic.
+type Partitioner interface {
+	Partition(key []byte, partitions []int32) (int32, error)
+}
+
+// BatchPartitioner is implemented by a Partitioner that needs to know when a
+// batch sent to a partition is completed.
+type BatchPartitioner interface {
+	Partitioner
+	OnNewBatch(prev int32)
+}
+
+// NewPartitionerFunc creates a Partitioner for a topic.
+type NewPartitionerFunc func(topic string) Partitioner
 
 type topicPartitioner struct {
-	m  map[string]*partitioner
+	m  map[string]Partitioner
 	mu sync.Mutex
 }
 
 func newTopicPartitioner() *topicPartitioner {
 	return &topicPartitioner{
-		m: make(map[string]*partitioner),
+		m: make(map[string]Partitioner),
 	}
 }
 
-func (tp *topicPartitioner) Get(topic string) *partitioner {
-	tp.mu.Lock()
-	defer tp.mu.Unlock()
-	return tp.m[topic]
-}
-
-func (tp *topicPartitioner) Add(topic string, partitions []int32) *partitioner {
+func (tp *topicPartitioner) Get(topic string, newPartitioner NewPartitionerFunc) Partitioner {
 	tp.mu.Lock()
 	defer tp.mu.Unlock()
 	partitioner := tp.m[topic]
 	if partitioner == nil {
-		partitioner = newPartitioner(partitions)
+		partitioner = newPartitioner(topic)
 		tp.m[topic] = partitioner
 	}
 	return partitioner
 }
 
+// Delete drops the partitioner of the topic, so that a new one is created on
+// the next Get.
 func (tp *topicPartitioner) Delete(topic string) {
 	tp.mu.Lock()
 	defer tp.mu.Unlock()
 	delete(tp.m, topic)
 }
 
-type partitioner struct {
-	partitions []int32
-	i          int
-	mu         sync.Mutex
+type roundRobinPartitioner struct {
+	i  int
+	mu sync.Mutex
 }
 
-func newPartitioner(partitions []int32) *partitioner {
-	return &partitioner{
-		partitions: partitions,
+// NewRoundRobinPartitioner returns a partitioner that ignores the key and
+// cycles through the partitions.
+func NewRoundRobinPartitioner(topic string) Partitioner {
+	return &roundRobinPartitioner{}
+}
+
+func (p *roundRobinPartitioner) Partition(key []byte, partitions []int32) (int32, error) {
+	if len(partitions) == 0 {
+		return -1, ErrNoValidPartition
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.i >= len(partitions) {
+		p.i = 0
 	}
+	partition := partitions[p.i]
+	p.i++
+	return partition, nil
+}
+
+type randomPartitioner struct {
+	rd *rand.Rand
+	mu sync.Mutex
 }
 
-func (p *partitioner) Count() int {
-	return len(p.partitions)
+// NewRandomPartitioner returns a partitioner that ignores the key and chooses
+// a random partition.
+func NewRandomPartitioner(topic string) Partitioner {
+	return &randomPartitioner{rd: rand.New(rand.NewSource(time.Now().UnixNano()))}
 }
 
-func (p *partitioner) Partition([]byte) (int32, error) {
+func (p *randomPartitioner) Partition(key []byte, partitions []int32) (int32, error) {
+	if len(partitions) == 0 {
+		return -1, ErrNoValidPartition
+	}
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	for i := 0; i < len(p.partitions); i++ {
-		partition := p.partitions[p.i]
-		p.i++
-		if p.i == len(p.partitions) {
-			p.i = 0
+	return partitions[p.rd.Intn(len(partitions))], nil
+}
+
+type hashPartitioner struct {
+	roundRobin roundRobinPartitioner
+}
+
+// NewHashPartitioner returns a partitioner compatible with the default
+// partitioner of the Java client: a keyed message goes to the partition
+// murmur2(key) % count, and keyless messages are distributed round-robin.
+func NewHashPartitioner(topic string) Partitioner {
+	return &hashPartitioner{}
+}
+
+func (p *hashPartitioner) Partition(key []byte, partitions []int32) (int32, error) {
+	if len(partitions) == 0 {
+		return -1, ErrNoValidPartition
+	}
+	if key == nil {
+		return p.roundRobin.Partition(key, partitions)
+	}
+	// like the Java client, partition IDs are assumed to be 0 ~ count-1
+	return int32(toPositive(murmur2(key)) % int32(len(partitions))), nil
+}
+
+type stickyPartitioner struct {
+	hash    hashPartitioner
+	current int32
+	prev    int32
+	rd      *rand.Rand
+	mu      sync.Mutex
+}
+
+// NewStickyPartitioner returns a partitioner that sends keyless messages to
+// one partition until the batch is completed and then switches to another
+// random partition, so that fewer and larger batches are sent. Keyed messages
+// are partitioned like NewHashPartitioner.
+func NewStickyPartitioner(topic string) Partitioner {
+	return &stickyPartitioner{
+		current: -1,
+		prev:    -1,
+		rd:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (p *stickyPartitioner) Partition(key []byte, partitions []int32) (int32, error) {
+	if key != nil {
+		return p.hash.Partition(key, partitions)
+	}
+	if len(partitions) == 0 {
+		return -1, ErrNoValidPartition
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.current >= 0 && containsPartition(partitions, p.current) {
+		return p.current, nil
+	}
+	i := p.rd.Intn(len(partitions))
+	if partitions[i] == p.prev && len(partitions) > 1 {
+		i = (i + 1 + p.rd.Intn(len(partitions)-1)) % len(partitions)
+	}
+	p.current = partitions[i]
+	return p.current, nil
+}
+
+// OnNewBatch switches to another partition if prev is the current sticky
+// partition.
+func (p *stickyPartitioner) OnNewBatch(prev int32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.current == prev {
+		p.current = -1
+		p.prev = prev
+	}
+}
+
+func containsPartition(partitions []int32, partition int32) bool {
+	for _, p := range partitions {
+		if p == partition {
+			return true
 		}
-		return partition, nil
 	}
-	return -1, ErrNoValidPartition
+	return false
+}
+
+type manualPartitioner struct{}
+
+// NewManualPartitioner returns a partitioner that rejects any message without
+// an explicit partition, i.e. only ProduceWithPartition can be used.
+func NewManualPartitioner(topic string) Partitioner {
+	return manualPartitioner{}
+}
+
+func (manualPartitioner) Partition(key []byte, partitions []int32) (int32, error) {
+	return -1, ErrManualPartition
+}
+
+// murmur2 is the same hash function as the one used by the Java client.
+func murmur2(data []byte) int32 {
+	const (
+		seed = uint32(0x9747b28c)
+		m    = uint32(0x5bd1e995)
+		r    = 24
+	)
+	length := len(data)
+	h := seed ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+func toPositive(n int32) int32 {
+	return n & 0x7fffffff
 }
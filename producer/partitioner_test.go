@@ -0,0 +1,92 @@
+package producer
+
+import (
+	"testing"
+)
+
+func TestMurmur2(t *testing.T) {
+	t.Parallel()
+	// test vectors from the Java client
+	for _, tc := range []struct {
+		key  string
+		hash int32
+	}{
+		{"21", -973932308},
+		{"foobar", -790332482},
+		{"a-little-bit-long-string", -985981536},
+		{"a-little-bit-longer-string", -1486304829},
+		{"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8", -58897971},
+		{"abc", 479470107},
+	} {
+		if hash := murmur2([]byte(tc.key)); hash != tc.hash {
+			t.Fatalf("murmur2(%q): expect %d but got %d", tc.key, tc.hash, hash)
+		}
+	}
+}
+
+func TestHashPartitioner(t *testing.T) {
+	t.Parallel()
+	p := NewHashPartitioner("test")
+	partitions := []int32{0, 1, 2, 3, 4, 5}
+	for _, key := range []string{"user-1", "user-2", "user-3"} {
+		first, err := p.Partition([]byte(key), partitions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := toPositive(murmur2([]byte(key))) % 6; first != expected {
+			t.Fatalf("expect partition %d but got %d", expected, first)
+		}
+		for i := 0; i < 10; i++ {
+			if partition, _ := p.Partition([]byte(key), partitions); partition != first {
+				t.Fatalf("key %s should always go to partition %d, got %d", key, first, partition)
+			}
+		}
+	}
+	seen := make(map[int32]bool)
+	for i := 0; i < len(partitions); i++ {
+		partition, _ := p.Partition(nil, partitions)
+		seen[partition] = true
+	}
+	if len(seen) != len(partitions) {
+		t.Fatalf("keyless messages should be distributed round-robin, got %v", seen)
+	}
+}
+
+func TestManualPartitioner(t *testing.T) {
+	t.Parallel()
+	if _, err := NewManualPartitioner("test").Partition([]byte("key"), []int32{0}); err != ErrManualPartition {
+		t.Fatalf("expect ErrManualPartition but got %v", err)
+	}
+}
+
+func TestStickyPartitioner(t *testing.T) {
+	t.Parallel()
+	p := NewStickyPartitioner("test").(BatchPartitioner)
+	partitions := []int32{0, 1, 2, 3}
+	first, err := p.Partition(nil, partitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if partition, _ := p.Partition(nil, partitions); partition != first {
+			t.Fatalf("expect sticky partition %d but got %d", first, partition)
+		}
+	}
+	p.OnNewBatch(first)
+	second, _ := p.Partition(nil, partitions)
+	if second == first {
+		t.Fatalf("expect a partition other than %d after a new batch", first)
+	}
+	// a stale notification should not switch the partition again
+	p.OnNewBatch(first)
+	if partition, _ := p.Partition(nil, partitions); partition != second {
+		t.Fatalf("expect sticky partition %d but got %d", second, partition)
+	}
+	if partition, _ := p.Partition([]byte("abc"), partitions); partition != toPositive(murmur2([]byte("abc")))%4 {
+		t.Fatalf("keyed message should be hashed, got %d", partition)
+	}
+	// the sticky partition disappears
+	if partition, _ := p.Partition(nil, []int32{9}); partition != 9 {
+		t.Fatalf("expect partition 9 but got %d", partition)
+	}
+}
@@ -2,12 +2,13 @@ package producer
 
 import (
 	"errors"
-	"fmt"
+	"sync"
 	"time"
 
 	"h12.io/kpax/log"
 	"h12.io/kpax/model"
 	"h12.io/kpax/proto"
+	"h12.io/kpax/ratelimit"
 )
 
 var (
@@ -16,73 +17,193 @@ var (
 )
 
 type P struct {
-	RequiredAcks     proto.ProduceAckType
-	AckTimeout       time.Duration
-	Cluster          model.Cluster
-	topicPartitioner *topicPartitioner
+	RequiredAcks       proto.ProduceAckType
+	AckTimeout         time.Duration
+	Compression        proto.CompressionCodec // only snappy is supported
+	Partitioner        NewPartitionerFunc
+	BatchSize          int                // in bytes, only for Send
+	MaxMessageSize     int                // in bytes, larger messages are rejected before being sent, 0 means unlimited
+	ChunkSize          int                // in bytes, a larger value is split into chunks to be joined by consumer.Reassembler, 0 disables chunking
+	Linger             time.Duration      // only for Send
+	Reports            chan<- *Record     // optional, receives the results of Send, it should be drained
+	BufferMemory       int                // in bytes, limits the messages buffered by Send, 0 means unlimited
+	BufferPolicy       BufferPolicy       // what Send does when BufferMemory is exceeded
+	BufferTimeout      time.Duration      // the longest time Send blocks with BlockOnFull
+	Retries            int                // retries on retriable errors
+	RetryBackoff       time.Duration      // doubles on each retry
+	StrictOrder        bool               // at most one batch in flight per partition for Send, so that retries never reorder messages
+	Idempotent         bool               // retries never duplicate or reorder messages, requires Kafka 0.11 and RequiredAcks is always AckAll
+	TransactionalID    string             // enables Transaction and implies Idempotent
+	TransactionTimeout time.Duration      // aborts a transaction not completed in time
+	Spool              *Spool             // optional, keeps the messages of Send failed with a retriable error, see StartReplay
+	ReplayAttempts     int                // attempts to replay a spooled message before it is dropped, 0 means unlimited
+	DeadLetterTopic    string             // optional, receives the messages of Send failed after retries or with a permanent error, see proto.DeadLetter
+	Limiter            *ratelimit.Limiter // optional, limits the messages and bytes per second sent, waited before each request
+	Cluster            model.Cluster
+	topicPartitioner   *topicPartitioner
+	topicConfigs       topicConfigs // overrides by SetTopicConfig
+	acc                *accumulator
+	txn                *Transaction
+	replayQuit         chan struct{}
+	replayDone         chan struct{}
+	replayMu           sync.Mutex
+	replayHead         *Record // the spooled record being replayed
+	replayFailures     int     // of replayHead
 }
 
 func New(cluster model.Cluster) *P {
-	return &P{
-		Cluster:          cluster,
-		topicPartitioner: newTopicPartitioner(),
-		RequiredAcks:     proto.AckLocal,
-		AckTimeout:       10 * time.Second,
+	p := &P{
+		Cluster:            cluster,
+		topicPartitioner:   newTopicPartitioner(),
+		RequiredAcks:       proto.AckLocal,
+		AckTimeout:         10 * time.Second,
+		Partitioner:        NewHashPartitioner,
+		BatchSize:          16 * 1024,
+		MaxMessageSize:     1000000, // message.max.bytes of the broker by default
+		Linger:             5 * time.Millisecond,
+		BufferMemory:       32 * 1024 * 1024,
+		BufferTimeout:      time.Minute,
+		Retries:            3,
+		RetryBackoff:       100 * time.Millisecond,
+		ReplayAttempts:     10,
+		TransactionTimeout: time.Minute,
 	}
+	p.acc = newAccumulator(p)
+	p.txn = &Transaction{p: p}
+	return p
 }
 
-func (p *P) ProduceMessageSet(topic string, messageSet proto.MessageSet) error {
+// Delivery tells where a message set is written. Offset is the offset of the
+// first message, or -1 if RequiredAcks is AckNone.
+type Delivery struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+}
+
+// ProduceMessageSet sends a message set to a partition chosen by the key of
+// the first message. A message set with a key is retried on the same
+// partition, while a message set without a key fails over to another
+// partition. A message set rejected for its size is split and sent to the
+// same partition in smaller requests.
+func (p *P) ProduceMessageSet(topic string, messageSet proto.MessageSet) (Delivery, error) {
 	if len(messageSet) == 0 {
 		panic("empty message set")
 	}
+	if p.closed() {
+		return Delivery{}, ErrClosed
+	}
+	if err := p.checkMessageSetSize(topic, messageSet); err != nil {
+		return Delivery{}, err
+	}
 	key := messageSet[0].Key
-	partitioner := p.topicPartitioner.Get(topic)
-	if partitioner == nil {
-		partitions, err := p.Cluster.Partitions(topic)
+	// partitions are cached by the cluster and may grow after a refresh
+	partitions, err := p.Cluster.Partitions(topic)
+	if err != nil {
+		return Delivery{}, err
+	}
+	config := p.topicSettings(topic)
+	partitioner := p.topicPartitioner.Get(topic, config.Partitioner)
+	if p.idempotent() {
+		partition, err := partitioner.Partition(key, partitions)
 		if err != nil {
-			return err
+			return Delivery{}, err
 		}
-		partitioner = p.topicPartitioner.Add(topic, partitions)
+		return p.produceIdempotent(topic, partition, messageSet)
 	}
-nextPartition:
-	for i := 0; i < partitioner.Count(); i++ {
-		partition, err := partitioner.Partition(key)
+	p.waitMessageSet(topic, messageSet)
+	for attempt := 0; ; attempt++ {
+		partition, err := partitioner.Partition(key, partitions)
 		if err != nil {
-			p.topicPartitioner.Delete(topic)
-			return err
+			return Delivery{}, err
 		}
-		if err := (&proto.Payload{
+		payload := &proto.Payload{
 			Topic:        topic,
 			Partition:    partition,
-			MessageSet:   messageSet,
-			RequiredAcks: p.RequiredAcks,
-			AckTimeout:   p.AckTimeout,
-		}).Produce(p.Cluster); err != nil {
-			log.Warnf("fail to produce to one partition %d in %s", partition, topic)
-			continue nextPartition
+			MessageSet:   messageSet.Compress(config.Compression),
+			RequiredAcks: config.RequiredAcks,
+			AckTimeout:   config.AckTimeout,
+		}
+		if key != nil {
+			err = p.produce(payload)
+		} else {
+			err = payload.Produce(p.Cluster)
 		}
-		return nil
+		// each message set is a batch of its own
+		onNewBatch(partitioner, partition)
+		if tooLarge(err) && len(messageSet) > 1 {
+			// no fail over because part of the message set may be written
+			if payload.Offset, err = p.produceSplit(topic, partition, messageSet, config); err != nil {
+				return Delivery{}, err
+			}
+		}
+		if err == nil {
+			return Delivery{Topic: topic, Partition: partition, Offset: payload.Offset}, nil
+		}
+		if key != nil || !retriable(err) || attempt >= p.Retries {
+			return Delivery{}, err
+		}
+		log.Warnf("fail to produce to one partition %d in %s: %v", partition, topic, err)
+		time.Sleep(p.backoff(attempt))
+	}
+}
+
+func (p *P) idempotent() bool {
+	return p.Idempotent || p.TransactionalID != ""
+}
+
+func onNewBatch(partitioner Partitioner, prev int32) {
+	if bp, ok := partitioner.(BatchPartitioner); ok {
+		bp.OnNewBatch(prev)
 	}
-	return fmt.Errorf("fail to produce to all partitions in %s", topic)
 }
 
-func (p *P) Produce(topic string, key, value []byte) error {
-	return p.ProduceMessageSet(topic, getMessageSet(key, value))
+// Produce sends a message to a partition chosen by the key. A value larger
+// than ChunkSize is sent in chunks, and Delivery has the offset of the first
+// one.
+func (p *P) Produce(topic string, key, value []byte) (Delivery, error) {
+	messageSet, err := p.messageSet(key, value)
+	if err != nil {
+		return Delivery{}, err
+	}
+	return p.ProduceMessageSet(topic, messageSet)
 }
 
-func (p *P) ProduceWithPartition(topic string, partition int32, key, value []byte) error {
-	messageSet := getMessageSet(key, value)
-	return (&proto.Payload{
+func (p *P) ProduceWithPartition(topic string, partition int32, key, value []byte) (Delivery, error) {
+	if p.closed() {
+		return Delivery{}, ErrClosed
+	}
+	if err := p.checkValueSize(topic, key, value); err != nil {
+		return Delivery{}, err
+	}
+	messageSet, err := p.messageSet(key, value)
+	if err != nil {
+		return Delivery{}, err
+	}
+	if p.idempotent() {
+		return p.produceIdempotent(topic, partition, messageSet)
+	}
+	p.waitMessageSet(topic, messageSet)
+	config := p.topicSettings(topic)
+	payload := &proto.Payload{
 		Topic:        topic,
 		Partition:    partition,
-		MessageSet:   messageSet,
-		RequiredAcks: p.RequiredAcks,
-		AckTimeout:   p.AckTimeout,
-	}).Produce(p.Cluster)
+		MessageSet:   messageSet.Compress(config.Compression),
+		RequiredAcks: config.RequiredAcks,
+		AckTimeout:   config.AckTimeout,
+	}
+	err = p.produce(payload)
+	if tooLarge(err) && len(messageSet) > 1 {
+		payload.Offset, err = p.produceSplit(topic, partition, messageSet, config)
+	}
+	if err != nil {
+		return Delivery{}, err
+	}
+	return Delivery{Topic: topic, Partition: partition, Offset: payload.Offset}, nil
 }
 
-func getMessageSet(key, value []byte) []proto.OffsetMessage {
-	return []proto.OffsetMessage{
+func getMessageSet(key, value []byte) proto.MessageSet {
+	return proto.MessageSet{
 		{
 			SizedMessage: proto.SizedMessage{CRCMessage: proto.CRCMessage{
 				Message: proto.Message{
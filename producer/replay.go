@@ -0,0 +1,188 @@
+package producer
+
+import (
+	"time"
+
+	"h12.io/kpax/log"
+)
+
+// spool writes the records of a batch failed with a retriable error to
+// P.Spool if any, except the ones of a synchronous Produce, whose error is
+// returned to the caller instead. The records dropped by the producer itself
+// on ErrClosed or ErrBufferFull are reported with the error, not spooled.
+func (a *accumulator) spool(b *batch, err error) {
+	if err == nil || err == ErrClosed || err == ErrBufferFull || a.p.Spool == nil || !retriable(err) {
+		return
+	}
+	a.spoolRecords(b)
+}
+
+// spoolRecords writes the records of a batch to P.Spool in order and marks
+// them Spooled, except the ones of a synchronous Produce. A chunk is spooled
+// by writing the whole value once for all of its chunks, so that the value is
+// replayed in new chunks.
+func (a *accumulator) spoolRecords(b *batch) {
+	var records []*Record
+	flush := func() {
+		if len(records) == 0 {
+			return
+		}
+		if err := a.p.Spool.Append(records...); err != nil {
+			log.Errorf("fail to spool %d messages for partition %d in %s: %v", len(records), b.partition, b.topic, err)
+		} else {
+			for _, r := range records {
+				r.Spooled = true
+			}
+		}
+		records = nil
+	}
+	for _, r := range b.records {
+		switch {
+		case r.sync:
+		case r.parent != nil:
+			flush()
+			r.Spooled = r.parent.spool(a.p.Spool)
+		default:
+			records = append(records, r)
+		}
+	}
+	flush()
+}
+
+// divert writes the records of a batch sent the first time to P.Spool
+// instead while the spool has a backlog for the partition, so that they are
+// not sent before the spooled records. The records of a synchronous Produce
+// are kept in the batch, which is returned unless it becomes empty.
+func (a *accumulator) divert(b *batch) *batch {
+	if b.sent {
+		return b
+	}
+	b.sent = true
+	if a.p.Spool == nil || a.p.Spool.Backlog(b.topic, b.partition) == 0 {
+		return b
+	}
+	a.spoolRecords(b)
+	var spooled, rest []*Record
+	for _, r := range b.records {
+		if r.Spooled {
+			spooled = append(spooled, r)
+		} else {
+			rest = append(rest, r)
+		}
+	}
+	if len(spooled) == 0 {
+		return b
+	}
+	size := recordsSize(spooled)
+	b.records, b.size = rest, b.size-size
+	for _, r := range spooled {
+		r.Offset = -1
+		if r.callback != nil {
+			r.callback(r)
+		}
+		if a.p.Reports != nil && !r.unreported {
+			a.p.Reports <- r
+		}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pending -= len(spooled)
+	a.buffered -= size
+	a.cond.Broadcast()
+	if len(rest) == 0 {
+		delete(a.inFlight, b.topicPartition)
+		if len(a.ready) > 0 {
+			a.notify()
+		}
+		return nil
+	}
+	return b
+}
+
+// StartReplay sends the records in Spool in order every interval in the
+// background until StopReplay is called. A replayed record is not reported.
+// A record failed with a retriable error stays in the spool to be replayed
+// next time, up to ReplayAttempts, so that a partition that never recovers
+// does not block the spool. A record failed permanently or too many times is
+// dropped, after being written to DeadLetterTopic if any. A non-positive
+// interval disables the replay.
+func (p *P) StartReplay(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	p.replayMu.Lock()
+	defer p.replayMu.Unlock()
+	if p.replayQuit != nil {
+		return
+	}
+	p.replayQuit = make(chan struct{})
+	p.replayDone = make(chan struct{})
+	go p.replayLoop(interval, p.replayQuit, p.replayDone)
+}
+
+// StopReplay stops the background replay and waits for it to exit.
+func (p *P) StopReplay() {
+	p.replayMu.Lock()
+	quit, done := p.replayQuit, p.replayDone
+	p.replayQuit, p.replayDone = nil, nil
+	p.replayMu.Unlock()
+	if quit != nil {
+		close(quit)
+		<-done
+	}
+}
+
+func (p *P) replayLoop(interval time.Duration, quit, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			p.replay(quit)
+		}
+	}
+}
+
+func (p *P) replay(quit chan struct{}) {
+	for {
+		select {
+		case <-quit:
+			return
+		default:
+		}
+		r, err := p.Spool.Peek()
+		if err != nil {
+			log.Errorf("fail to read spool: %v", err)
+			return
+		}
+		if r == nil {
+			return
+		}
+		if r != p.replayHead {
+			p.replayHead, p.replayFailures = r, 0
+		}
+		if _, err := p.ProduceWithPartition(r.Topic, r.Partition, r.Key, r.Value); err != nil {
+			p.replayFailures++
+			if retriable(err) && (p.ReplayAttempts <= 0 || p.replayFailures < p.ReplayAttempts) {
+				log.Warnf("fail to replay spool to partition %d in %s: %v", r.Partition, r.Topic, err)
+				return
+			}
+			p.dropSpooled(r, err)
+		}
+		if err := p.Spool.Commit(); err != nil {
+			log.Errorf("fail to commit spool: %v", err)
+			return
+		}
+	}
+}
+
+func (p *P) dropSpooled(r *Record, err error) {
+	log.Errorf("drop spooled message for partition %d in %s after %d attempts: %v", r.Partition, r.Topic, p.replayFailures, err)
+	if p.DeadLetterTopic == "" || r.Topic == p.DeadLetterTopic {
+		return
+	}
+	p.writeDeadLetter(r, err, p.replayFailures, time.Now())
+}
@@ -0,0 +1,44 @@
+package producer
+
+import (
+	"time"
+
+	"h12.io/kpax/broker"
+	"h12.io/kpax/log"
+	"h12.io/kpax/proto"
+)
+
+// the backoff doubles on each retry up to 32 times of RetryBackoff
+const maxBackoffShift = 5
+
+func retriable(err error) bool {
+	switch err.(type) {
+	case *broker.SizeError, *MessageSizeError:
+		return false
+	}
+	return proto.IsRetriable(err)
+}
+
+func (p *P) backoff(attempt int) time.Duration {
+	if attempt > maxBackoffShift {
+		attempt = maxBackoffShift
+	}
+	return p.RetryBackoff << uint(attempt)
+}
+
+// produce sends the payload to its partition, and retries on retriable errors.
+// Payload.Produce marks the leader as down on error, so that the metadata is
+// reloaded before the next attempt.
+func (p *P) produce(payload *proto.Payload) error {
+	for attempt := 0; ; attempt++ {
+		err := payload.Produce(p.Cluster)
+		if err == nil {
+			return nil
+		}
+		if !retriable(err) || attempt >= p.Retries {
+			return err
+		}
+		log.Warnf("retry producing to partition %d in %s: %v", payload.Partition, payload.Topic, err)
+		time.Sleep(p.backoff(attempt))
+	}
+}
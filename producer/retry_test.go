@@ -0,0 +1,117 @@
+package producer
+
+import (
+	"testing"
+	"time"
+
+	"h12.io/kpax/proto"
+)
+
+func TestProduceRetrySamePartition(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b, b, b)
+	key := []byte("user-1")
+	partition := toPositive(murmur2(key)) % 3
+	b.fail("a", partition, proto.ErrNotLeaderForPartition, proto.ErrLeaderNotAvailable)
+	p := New(c)
+	p.RetryBackoff = time.Millisecond
+	d, err := p.Produce("a", key, []byte("v"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Partition != partition {
+		t.Fatalf("keyed message should stay on partition %d, got %d", partition, d.Partition)
+	}
+	if n := c.leaderDownCount(); n != 2 {
+		t.Fatalf("expect the leader marked down twice but got %d", n)
+	}
+	if n := b.requestCount(); n != 3 {
+		t.Fatalf("expect 3 requests but got %d", n)
+	}
+}
+
+func TestProduceRetryExhausted(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b)
+	b.setError("a", 0, proto.ErrNotLeaderForPartition)
+	p := New(c)
+	p.Retries = 2
+	p.RetryBackoff = time.Millisecond
+	if _, err := p.ProduceWithPartition("a", 0, nil, []byte("v")); err != proto.ErrNotLeaderForPartition {
+		t.Fatalf("expect ErrNotLeaderForPartition but got %v", err)
+	}
+	if n := b.requestCount(); n != 3 {
+		t.Fatalf("expect 3 requests but got %d", n)
+	}
+}
+
+func TestProducePermanentError(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b, b)
+	b.setError("a", 0, proto.ErrMessageSizeTooLarge)
+	b.setError("a", 1, proto.ErrMessageSizeTooLarge)
+	p := New(c)
+	p.RetryBackoff = time.Millisecond
+	if _, err := p.Produce("a", nil, []byte("v")); err != proto.ErrMessageSizeTooLarge {
+		t.Fatalf("expect ErrMessageSizeTooLarge but got %v", err)
+	}
+	if n := b.requestCount(); n != 1 {
+		t.Fatalf("permanent error should not be retried, got %d requests", n)
+	}
+}
+
+func TestProduceKeylessFailover(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b, b)
+	b.setError("a", 0, proto.ErrNotLeaderForPartition)
+	p := New(c)
+	p.Partitioner = NewRoundRobinPartitioner
+	p.RetryBackoff = time.Millisecond
+	d, err := p.Produce("a", nil, []byte("v"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Partition != 1 {
+		t.Fatalf("expect failing over to partition 1 but got %d", d.Partition)
+	}
+}
+
+func TestSendRetry(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b)
+	b.fail("a", 0, proto.ErrNotLeaderForPartition, proto.ErrRequestTimedOut)
+	b.setError("b", 0, proto.ErrTopicAuthorizationFailedCode)
+	c.setTopic("b", b)
+	p := New(c)
+	p.Linger = time.Hour
+	p.RetryBackoff = time.Millisecond
+	var res results
+	p.Send("a", []byte("k"), []byte("v"), res.add)
+	p.Send("b", []byte("k"), []byte("v"), res.add)
+	p.Flush()
+	for _, r := range res.get() {
+		switch r.Topic {
+		case "a":
+			if r.Err != nil || r.Offset != 0 {
+				t.Fatalf("unexpected result %v", r)
+			}
+		case "b":
+			if r.Err != proto.ErrTopicAuthorizationFailedCode {
+				t.Fatalf("expect authorization error but got %v", r.Err)
+			}
+		}
+	}
+	if n := len(b.messages("a", 0)); n != 1 {
+		t.Fatalf("expect 1 message but got %d", n)
+	}
+}
@@ -0,0 +1,123 @@
+package producer
+
+import (
+	"fmt"
+
+	"h12.io/kpax/broker"
+	"h12.io/kpax/log"
+	"h12.io/kpax/proto"
+)
+
+// MessageSizeError is returned before sending a message larger than
+// MaxMessageSize, which the broker would reject anyway.
+type MessageSizeError struct {
+	Topic string
+	Size  int
+	Max   int
+}
+
+func (e *MessageSizeError) Error() string {
+	return fmt.Sprintf("producer: message size %d exceeds MaxMessageSize %d of topic %s", e.Size, e.Max, e.Topic)
+}
+
+func (p *P) checkSize(topic string, key, value []byte) error {
+	return p.checkMessageSize(topic, messageOverhead+len(key)+len(value))
+}
+
+// checkValueSize checks a value about to be split into chunks if it is
+// larger than ChunkSize, so that the largest chunk is checked instead of the
+// whole value. Only the methods that split the value call it.
+func (p *P) checkValueSize(topic string, key, value []byte) error {
+	if !p.chunked(value) {
+		return p.checkSize(topic, key, value)
+	}
+	return p.checkMessageSize(topic, messageOverhead+len(key)+proto.ChunkHeaderSize+p.ChunkSize)
+}
+
+func (p *P) checkMessageSize(topic string, size int) error {
+	if p.MaxMessageSize > 0 && size > p.MaxMessageSize {
+		return &MessageSizeError{Topic: topic, Size: size, Max: p.MaxMessageSize}
+	}
+	return nil
+}
+
+func (p *P) checkMessageSetSize(topic string, messageSet proto.MessageSet) error {
+	for i := range messageSet {
+		m := &messageSet[i].Message
+		if err := p.checkSize(topic, m.Key, m.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tooLarge returns true if a request is rejected for its size, so that
+// sending fewer messages at a time may succeed.
+func tooLarge(err error) bool {
+	switch err {
+	case proto.ErrMessageSizeTooLarge, proto.ErrRecordListTooLargeCode:
+		return true
+	}
+	sizeErr, ok := err.(*broker.SizeError)
+	return ok && !sizeErr.Response
+}
+
+// produceSplit sends a message set rejected for its size in two halves to the
+// same partition, and splits a half again if it is still too large. It returns
+// the offset of the first message. The first half stays written if the second
+// one fails.
+func (p *P) produceSplit(topic string, partition int32, messageSet proto.MessageSet, config topicSettings) (int64, error) {
+	log.Warnf("split %d messages to partition %d in %s", len(messageSet), partition, topic)
+	n := len(messageSet) / 2
+	offset := int64(-1)
+	for i, half := range []proto.MessageSet{messageSet[:n], messageSet[n:]} {
+		payload := &proto.Payload{
+			Topic:        topic,
+			Partition:    partition,
+			MessageSet:   half.Compress(config.Compression),
+			RequiredAcks: config.RequiredAcks,
+			AckTimeout:   config.AckTimeout,
+		}
+		err := p.produce(payload)
+		if tooLarge(err) && len(half) > 1 {
+			payload.Offset, err = p.produceSplit(topic, partition, half, config)
+		}
+		if err != nil {
+			return -1, err
+		}
+		if i == 0 {
+			offset = payload.Offset
+		}
+	}
+	return offset, nil
+}
+
+// split replaces a batch rejected for its size with its two halves, which are
+// sent before the other ready batches of the partition. The halves of an
+// idempotent batch keep the sequence numbers of their records.
+func (a *accumulator) split(b *batch, err error) {
+	a.mu.Lock()
+	if a.aborted {
+		a.mu.Unlock()
+		a.complete(b, -1, ErrClosed)
+		return
+	}
+	log.Warnf("split a batch of %d messages to partition %d in %s: %v", len(b.records), b.partition, b.topic, err)
+	n := len(b.records) / 2
+	first, second := *b, *b
+	first.records, second.records = b.records[:n], b.records[n:]
+	first.size, second.size = recordsSize(first.records), recordsSize(second.records)
+	second.sequence = nextSequence(b.sequence, n)
+	delete(a.inFlight, b.topicPartition)
+	a.ready = append([]*batch{&first, &second}, a.ready...)
+	a.notify()
+	a.mu.Unlock()
+}
+
+func recordsSize(records []*Record) int {
+	size := 0
+	for _, r := range records {
+		size += r.size()
+	}
+	return size
+}
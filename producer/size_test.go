@@ -0,0 +1,110 @@
+package producer
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"h12.io/kpax/proto"
+)
+
+func TestMessageSizeLimit(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b)
+	p := New(c)
+	p.MaxMessageSize = 100
+	value := make([]byte, 100)
+	if err := p.Send("a", nil, value, nil); err == nil {
+		t.Fatal("expect MessageSizeError from Send")
+	} else if sizeErr, ok := err.(*MessageSizeError); !ok || sizeErr.Size != messageOverhead+100 || sizeErr.Max != 100 {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := p.Produce("a", nil, value); err == nil {
+		t.Fatal("expect MessageSizeError from Produce")
+	}
+	if _, err := p.ProduceWithPartition("a", 0, nil, value); err == nil {
+		t.Fatal("expect MessageSizeError from ProduceWithPartition")
+	}
+	p.Flush()
+	if n := b.requestCount(); n != 0 {
+		t.Fatalf("expect no request but got %d", n)
+	}
+	if _, err := p.Produce("a", nil, value[:10]); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// the halves keep the order with StrictOrder or Idempotent like retries
+func testSplitBatch(t *testing.T, idempotent bool) {
+	b := newFakeBroker()
+	b.setMaxBatch(3)
+	c := newFakeCluster()
+	c.setTopic("a", b)
+	p := New(c)
+	p.Linger = time.Hour
+	p.StrictOrder = true
+	p.Idempotent = idempotent
+	var res results
+	for i := 0; i < 8; i++ {
+		if err := p.Send("a", nil, []byte(strconv.Itoa(i)), res.add); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Flush()
+	for _, r := range res.get() {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+	}
+	m := b.messages("a", 0)
+	if len(m) != 8 {
+		t.Fatalf("expect 8 messages but got %d", len(m))
+	}
+	for i := range m {
+		if string(m[i].Value) != strconv.Itoa(i) {
+			t.Fatalf("expect message %d but got %s", i, m[i].Value)
+		}
+	}
+}
+
+func TestSplitBatch(t *testing.T) {
+	t.Parallel()
+	testSplitBatch(t, false)
+}
+
+func TestSplitIdempotentBatch(t *testing.T) {
+	t.Parallel()
+	testSplitBatch(t, true)
+}
+
+func TestProduceMessageSetSplit(t *testing.T) {
+	t.Parallel()
+	b0, b1 := newFakeBroker(), newFakeBroker()
+	b0.setMaxBatch(2)
+	b1.setMaxBatch(2)
+	c := newFakeCluster()
+	c.setTopic("a", b0, b1)
+	p := New(c)
+	var ms proto.MessageSet
+	for i := 0; i < 5; i++ {
+		ms = append(ms, getMessageSet(nil, []byte(strconv.Itoa(i)))...)
+	}
+	d, err := p.ProduceMessageSet("a", ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Offset != 0 {
+		t.Fatalf("expect offset 0 but got %d", d.Offset)
+	}
+	written := append(b0.messages("a", 0), b1.messages("a", 1)...)
+	if len(written) != 5 {
+		t.Fatalf("expect 5 messages on one partition but got %d", len(written))
+	}
+	for i := range written {
+		if string(written[i].Value) != strconv.Itoa(i) {
+			t.Fatalf("expect message %d but got %s", i, written[i].Value)
+		}
+	}
+}
@@ -0,0 +1,493 @@
+package producer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrSpoolFull    = errors.New("spool is full")
+	ErrSpoolCorrupt = errors.New("spool is corrupt")
+)
+
+const (
+	spoolSuffix     = ".spool"
+	spoolCursorFile = "cursor"
+	// length and CRC of a frame
+	spoolFrameHeader = 8
+)
+
+// Spool is a write-ahead queue of records in segment files of a directory.
+// The producer appends the records that fail to be sent, and replays them in
+// order when the cluster recovers (see StartReplay). Later records of a
+// partition with a backlog are appended behind it instead of being sent, so
+// that they are not sent before the earlier ones, except the ones already in
+// flight with StrictOrder off. The records not replayed yet are kept after a
+// restart.
+type Spool struct {
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+	segments     []int64 // sequence numbers of the segment files in order
+	size         int64   // total size of the segment files
+	w            *os.File
+	wSize        int64
+	r            *os.File
+	rb           *bufio.Reader
+	cursor       int64   // offset of the next record in the first segment
+	next         *Record // read but not committed
+	nextSize     int64
+	backlog      map[topicPartition]int // records not committed per partition
+	mu           sync.Mutex
+}
+
+// OpenSpool opens or creates a spool in the directory, which can hold at most
+// maxBytes. A partial record written before a crash is discarded.
+func OpenSpool(dir string, maxBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Spool{
+		dir:          dir,
+		maxBytes:     maxBytes,
+		segmentBytes: maxBytes / 8,
+		backlog:      make(map[topicPartition]int),
+	}
+	if s.segmentBytes < 4096 {
+		s.segmentBytes = 4096
+	}
+	if err := s.load(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Spool) load() error {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasSuffix(name, spoolSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseInt(strings.TrimSuffix(name, spoolSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, seq)
+	}
+	sort.Sort(int64s(s.segments))
+	segment, cursor, err := s.readCursor()
+	if err != nil {
+		return err
+	}
+	// segments before the cursor are already replayed
+	for len(s.segments) > 0 && s.segments[0] < segment {
+		if err := os.Remove(s.segmentPath(s.segments[0])); err != nil {
+			return err
+		}
+		s.segments = s.segments[1:]
+	}
+	if len(s.segments) == 0 || s.segments[0] != segment {
+		cursor = 0
+	}
+	if len(s.segments) == 0 {
+		s.segments = []int64{segment}
+	}
+	last := s.segments[len(s.segments)-1]
+	if err := s.truncatePartial(last); err != nil {
+		return err
+	}
+	for _, seq := range s.segments {
+		fi, err := os.Stat(s.segmentPath(seq))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			s.size += fi.Size()
+		}
+	}
+	if s.w, err = os.OpenFile(s.segmentPath(last), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return err
+	}
+	fi, err := s.w.Stat()
+	if err != nil {
+		return err
+	}
+	s.wSize = fi.Size()
+	if err := s.countBacklog(cursor); err != nil {
+		return err
+	}
+	return s.openReader(cursor)
+}
+
+// countBacklog counts the records not replayed yet per partition.
+func (s *Spool) countBacklog(cursor int64) error {
+	for i, seq := range s.segments {
+		offset := int64(0)
+		if i == 0 {
+			offset = cursor
+		}
+		if err := s.countSegment(seq, offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Spool) countSegment(seq, offset int64) error {
+	f, err := os.Open(s.segmentPath(seq))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(f)
+	for {
+		rec, n, err := readFrame(r, s.frameLimit(fi.Size()-offset))
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		offset += n
+		s.backlog[topicPartition{rec.Topic, rec.Partition}]++
+	}
+}
+
+// truncatePartial cuts off the frames after the first invalid one.
+func (s *Spool) truncatePartial(seq int64) error {
+	f, err := os.OpenFile(s.segmentPath(seq), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(f)
+	var valid int64
+	for {
+		_, n, err := readFrame(r, s.frameLimit(fi.Size()-valid))
+		if err != nil {
+			break
+		}
+		valid += n
+	}
+	return f.Truncate(valid)
+}
+
+func (s *Spool) openReader(cursor int64) error {
+	f, err := os.Open(s.segmentPath(s.segments[0]))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(cursor, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	if s.r != nil {
+		s.r.Close()
+	}
+	s.r, s.rb, s.cursor = f, bufio.NewReader(f), cursor
+	return nil
+}
+
+// Append writes the records at the end of the spool. Either all or none of
+// them are written, and ErrSpoolFull is returned if they do not fit.
+func (s *Spool) Append(records ...*Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w == nil {
+		return os.ErrClosed
+	}
+	var buf []byte
+	for _, r := range records {
+		buf = appendFrame(buf, r)
+	}
+	if s.maxBytes > 0 && s.size+int64(len(buf)) > s.maxBytes {
+		return ErrSpoolFull
+	}
+	if s.wSize > 0 && s.wSize+int64(len(buf)) > s.segmentBytes {
+		if err := s.roll(); err != nil {
+			return err
+		}
+	}
+	n, err := s.w.Write(buf)
+	s.wSize += int64(n)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if err := s.w.Sync(); err != nil {
+		return err
+	}
+	for _, r := range records {
+		s.backlog[topicPartition{r.Topic, r.Partition}]++
+	}
+	return nil
+}
+
+// roll starts a new segment for writing.
+func (s *Spool) roll() error {
+	seq := s.segments[len(s.segments)-1] + 1
+	w, err := os.OpenFile(s.segmentPath(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.w.Close()
+	s.w, s.wSize = w, 0
+	s.segments = append(s.segments, seq)
+	return nil
+}
+
+// Peek returns the oldest record not committed yet, or nil if the spool is
+// empty.
+func (s *Spool) Peek() (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.r == nil {
+		return nil, os.ErrClosed
+	}
+	for s.next == nil {
+		fi, err := s.r.Stat()
+		if err != nil {
+			return nil, err
+		}
+		r, n, err := readFrame(s.rb, s.frameLimit(fi.Size()-s.cursor))
+		if err == io.EOF {
+			if len(s.segments) == 1 {
+				return nil, nil
+			}
+			// the segment is replayed, move on to the next one
+			if err := s.dropSegment(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.next, s.nextSize = r, n
+	}
+	return s.next, nil
+}
+
+// Commit removes the record returned by Peek from the spool.
+func (s *Spool) Commit() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.next == nil {
+		return nil
+	}
+	tp := topicPartition{s.next.Topic, s.next.Partition}
+	if s.backlog[tp]--; s.backlog[tp] <= 0 {
+		delete(s.backlog, tp)
+	}
+	s.next = nil
+	s.cursor += s.nextSize
+	return s.writeCursor()
+}
+
+func (s *Spool) dropSegment() error {
+	seq := s.segments[0]
+	fi, err := s.r.Stat()
+	if err != nil {
+		return err
+	}
+	s.segments = s.segments[1:]
+	if err := s.openReader(0); err != nil {
+		return err
+	}
+	if err := s.writeCursor(); err != nil {
+		return err
+	}
+	s.size -= fi.Size()
+	return os.Remove(s.segmentPath(seq))
+}
+
+// Backlog returns the number of records of a partition not replayed yet.
+func (s *Spool) Backlog(topic string, partition int32) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.backlog[topicPartition{topic, partition}]
+}
+
+// Size returns the bytes taken by the spool on disk.
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	if s.w != nil {
+		err = s.w.Close()
+		s.w = nil
+	}
+	if s.r != nil {
+		s.r.Close()
+		s.r = nil
+	}
+	return err
+}
+
+// frameLimit returns the largest valid frame given the bytes remaining in the
+// segment.
+func (s *Spool) frameLimit(remaining int64) int64 {
+	if s.maxBytes > 0 && s.maxBytes < remaining {
+		return s.maxBytes
+	}
+	return remaining
+}
+
+func (s *Spool) segmentPath(seq int64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolSuffix))
+}
+
+// the cursor file holds the sequence number of the first segment and the
+// offset of the next record in it
+func (s *Spool) readCursor() (segment, offset int64, err error) {
+	buf, err := ioutil.ReadFile(filepath.Join(s.dir, spoolCursorFile))
+	if os.IsNotExist(err) {
+		if len(s.segments) > 0 {
+			return s.segments[0], 0, nil
+		}
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(buf) != 16 {
+		return 0, 0, ErrSpoolCorrupt
+	}
+	return int64(binary.BigEndian.Uint64(buf)), int64(binary.BigEndian.Uint64(buf[8:])), nil
+}
+
+// writeCursor replaces the cursor file atomically.
+func (s *Spool) writeCursor() error {
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(s.segments[0]))
+	binary.BigEndian.PutUint64(buf[8:], uint64(s.cursor))
+	path := filepath.Join(s.dir, spoolCursorFile)
+	if err := ioutil.WriteFile(path+".tmp", buf[:], 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// a frame is the length and CRC of the payload followed by the payload:
+// topic, partition, key and value
+func appendFrame(buf []byte, r *Record) []byte {
+	start := len(buf)
+	buf = append(buf, make([]byte, spoolFrameHeader)...)
+	buf = appendBytes(buf, []byte(r.Topic))
+	buf = appendUint32(buf, uint32(r.Partition))
+	buf = appendBytes(buf, r.Key)
+	buf = appendBytes(buf, r.Value)
+	payload := buf[start+spoolFrameHeader:]
+	binary.BigEndian.PutUint32(buf[start:], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[start+4:], crc32.ChecksumIEEE(payload))
+	return buf
+}
+
+func appendUint32(buf []byte, i uint32) []byte {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], i)
+	return append(buf, b[:]...)
+}
+
+// appendBytes writes the length before the bytes, or -1 for nil
+func appendBytes(buf, b []byte) []byte {
+	if b == nil {
+		return appendUint32(buf, ^uint32(0))
+	}
+	buf = appendUint32(buf, uint32(len(b)))
+	return append(buf, b...)
+}
+
+// readFrame returns io.EOF at the end, or ErrSpoolCorrupt for a partial or
+// invalid frame, including one longer than max bytes.
+func readFrame(r *bufio.Reader, max int64) (*Record, int64, error) {
+	var header [spoolFrameHeader]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, ErrSpoolCorrupt
+	}
+	size := spoolFrameHeader + int64(binary.BigEndian.Uint32(header[:]))
+	if size > max {
+		return nil, 0, ErrSpoolCorrupt
+	}
+	payload := make([]byte, size-spoolFrameHeader)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, 0, ErrSpoolCorrupt
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, 0, ErrSpoolCorrupt
+	}
+	var rec Record
+	topic, payload, ok := readBytes(payload)
+	if !ok || len(payload) < 4 {
+		return nil, 0, ErrSpoolCorrupt
+	}
+	rec.Topic = string(topic)
+	rec.Partition = int32(binary.BigEndian.Uint32(payload))
+	if rec.Key, payload, ok = readBytes(payload[4:]); !ok {
+		return nil, 0, ErrSpoolCorrupt
+	}
+	if rec.Value, payload, ok = readBytes(payload); !ok || len(payload) != 0 {
+		return nil, 0, ErrSpoolCorrupt
+	}
+	return &rec, size, nil
+}
+
+func readBytes(buf []byte) (b, rest []byte, ok bool) {
+	if len(buf) < 4 {
+		return nil, nil, false
+	}
+	n := binary.BigEndian.Uint32(buf)
+	buf = buf[4:]
+	if n == ^uint32(0) {
+		return nil, buf, true
+	}
+	if uint64(n) > uint64(len(buf)) {
+		return nil, nil, false
+	}
+	return buf[:n], buf[n:], true
+}
+
+type int64s []int64
+
+func (s int64s) Len() int           { return len(s) }
+func (s int64s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s int64s) Less(i, j int) bool { return s[i] < s[j] }
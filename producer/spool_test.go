@@ -0,0 +1,331 @@
+package producer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"h12.io/kpax/proto"
+)
+
+func newSpoolDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "kpax-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func replayAll(t *testing.T, s *Spool) []*Record {
+	var records []*Record
+	for {
+		r, err := s.Peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r == nil {
+			return records
+		}
+		records = append(records, r)
+		if err := s.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSpoolRestart(t *testing.T) {
+	t.Parallel()
+	dir := newSpoolDir(t)
+	defer os.RemoveAll(dir)
+	s, err := OpenSpool(dir, 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := make([]byte, 1000)
+	// several segments of 128KB each
+	for i := 0; i < 300; i++ {
+		if err := s.Append(&Record{Topic: "a", Partition: int32(i % 3), Key: []byte(strconv.Itoa(i)), Value: value}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		if _, err := s.Peek(); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// not committed, so replayed again after the restart
+	if _, err := s.Peek(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = OpenSpool(dir, 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	records := replayAll(t, s)
+	if len(records) != 100 {
+		t.Fatalf("expect 100 records but got %d", len(records))
+	}
+	for i, r := range records {
+		if string(r.Key) != strconv.Itoa(200+i) || r.Partition != int32((200+i)%3) || len(r.Value) != 1000 {
+			t.Fatalf("unexpected record %d: %s %d", i, r.Key, r.Partition)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+spoolSuffix))
+	if len(files) != 1 {
+		t.Fatalf("expect replayed segments deleted but got %v", files)
+	}
+}
+
+func TestSpoolSizeCap(t *testing.T) {
+	t.Parallel()
+	dir := newSpoolDir(t)
+	defer os.RemoveAll(dir)
+	s, err := OpenSpool(dir, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	value := make([]byte, 1000)
+	n := 0
+	for ; ; n++ {
+		err := s.Append(&Record{Topic: "a", Value: value})
+		if err == ErrSpoolFull {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n == 0 || s.Size() > 10000 {
+		t.Fatalf("unexpected size %d after %d records", s.Size(), n)
+	}
+	// space is released after the segments are replayed
+	replayAll(t, s)
+	if err := s.Append(&Record{Topic: "a", Value: value}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSpoolPartialWrite(t *testing.T) {
+	t.Parallel()
+	dir := newSpoolDir(t)
+	defer os.RemoveAll(dir)
+	s, err := OpenSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append(&Record{Topic: "a", Value: []byte("0")}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	// a crash in the middle of a write
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+spoolSuffix))
+	f, err := os.OpenFile(files[0], os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(appendFrame(nil, &Record{Topic: "a", Value: []byte("1")})[:10])
+	f.Close()
+
+	s, err = OpenSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Append(&Record{Topic: "a", Value: []byte("2")}); err != nil {
+		t.Fatal(err)
+	}
+	records := replayAll(t, s)
+	if len(records) != 2 || string(records[0].Value) != "0" || string(records[1].Value) != "2" {
+		t.Fatalf("unexpected records %v", records)
+	}
+}
+
+func TestSendSpoolAndReplay(t *testing.T) {
+	t.Parallel()
+	dir := newSpoolDir(t)
+	defer os.RemoveAll(dir)
+	s, err := OpenSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", nil)
+	p := New(c)
+	p.Retries = 0
+	p.Spool = s
+	var res results
+	for i := 0; i < 3; i++ {
+		p.SendWithPartition("a", 0, nil, []byte(strconv.Itoa(i)), res.add)
+	}
+	p.Flush()
+	for _, r := range res.get() {
+		if r.Err != nil || !r.Spooled {
+			t.Fatalf("expect spooled but got %v", r.Err)
+		}
+	}
+	// the cluster recovers
+	c.setTopic("a", b)
+	p.StartReplay(time.Millisecond)
+	defer p.StopReplay()
+	for start := time.Now(); len(b.messages("a", 0)) < 3; {
+		if time.Since(start) > time.Second {
+			t.Fatal("spool is not replayed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i, m := range b.messages("a", 0) {
+		if string(m.Value) != strconv.Itoa(i) {
+			t.Fatalf("expect message %d but got %s", i, m.Value)
+		}
+	}
+}
+
+func TestSpoolFrameLength(t *testing.T) {
+	t.Parallel()
+	dir := newSpoolDir(t)
+	defer os.RemoveAll(dir)
+	s, err := OpenSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Append(&Record{Topic: "a", Value: []byte("0")}); err != nil {
+		t.Fatal(err)
+	}
+	// a corrupted length must not be trusted for the allocation
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+spoolSuffix))
+	f, err := os.OpenFile(files[0], os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, 0)
+	f.Close()
+	if _, err := s.Peek(); err != ErrSpoolCorrupt {
+		t.Fatalf("expect ErrSpoolCorrupt but got %v", err)
+	}
+}
+
+func TestSendBehindSpool(t *testing.T) {
+	t.Parallel()
+	dir := newSpoolDir(t)
+	defer os.RemoveAll(dir)
+	s, err := OpenSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", nil)
+	p := New(c)
+	p.Retries = 0
+	p.StrictOrder = true
+	p.Spool = s
+	var res results
+	p.SendWithPartition("a", 0, nil, []byte("0"), res.add)
+	p.Flush()
+	// the cluster recovers before the spool is replayed
+	c.setTopic("a", b)
+	for i := 1; i < 3; i++ {
+		p.SendWithPartition("a", 0, nil, []byte(strconv.Itoa(i)), res.add)
+	}
+	p.Flush()
+	for _, r := range res.get() {
+		if r.Err != nil || !r.Spooled {
+			t.Fatalf("expect spooled but got %v", r.Err)
+		}
+	}
+	if n := len(b.messages("a", 0)); n != 0 {
+		t.Fatalf("expect no message sent before the spool but got %d", n)
+	}
+	if n := s.Backlog("a", 0); n != 3 {
+		t.Fatalf("expect backlog 3 but got %d", n)
+	}
+	p.StartReplay(time.Millisecond)
+	defer p.StopReplay()
+	for start := time.Now(); len(b.messages("a", 0)) < 3; {
+		if time.Since(start) > time.Second {
+			t.Fatal("spool is not replayed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i, m := range b.messages("a", 0) {
+		if string(m.Value) != strconv.Itoa(i) {
+			t.Fatalf("expect message %d but got %s", i, m.Value)
+		}
+	}
+	// sent directly once the backlog is replayed
+	if _, err := p.ProduceWithPartition("a", 0, nil, []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	var direct results
+	p.SendWithPartition("a", 0, nil, []byte("4"), direct.add)
+	p.Flush()
+	if r := direct.get(); len(r) != 1 || r[0].Spooled || r[0].Err != nil {
+		t.Fatalf("expect sent directly but got %v", r)
+	}
+}
+
+func TestReplayAttempts(t *testing.T) {
+	t.Parallel()
+	dir := newSpoolDir(t)
+	defer os.RemoveAll(dir)
+	s, err := OpenSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	b := newFakeBroker()
+	b.setError("gone", 0, proto.ErrUnknownTopicOrPartition)
+	c := newFakeCluster()
+	c.setTopic("gone", b)
+	c.setTopic("a", b)
+	c.setTopic("dead", b)
+	p := New(c)
+	p.Retries = 0
+	p.ReplayAttempts = 3
+	p.DeadLetterTopic = "dead"
+	p.Spool = s
+	if err := s.Append(&Record{Topic: "gone", Value: []byte("0")}, &Record{Topic: "a", Value: []byte("1")}); err != nil {
+		t.Fatal(err)
+	}
+	p.StartReplay(time.Millisecond)
+	defer p.StopReplay()
+	for start := time.Now(); len(b.messages("a", 0)) < 1; {
+		if time.Since(start) > time.Second {
+			t.Fatal("spool is blocked by a record failing forever")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	dead := b.messages("dead", 0)
+	if len(dead) != 1 {
+		t.Fatalf("expect 1 dead letter but got %d", len(dead))
+	}
+	var d proto.DeadLetter
+	if err := d.Unmarshal(dead[0].Value); err != nil {
+		t.Fatal(err)
+	}
+	if d.Topic != "gone" || string(d.Value) != "0" || d.Attempts != 3 {
+		t.Fatalf("unexpected dead letter %+v", d)
+	}
+}
+
+func TestReplayDisabled(t *testing.T) {
+	t.Parallel()
+	p := New(newFakeCluster())
+	p.StartReplay(0)
+	p.StartReplay(-time.Second)
+	p.StopReplay()
+}
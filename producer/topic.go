@@ -0,0 +1,110 @@
+package producer
+
+import (
+	"sync"
+	"time"
+
+	"h12.io/kpax/proto"
+)
+
+// TopicConfig overrides some settings of P for a topic. A nil or zero field is
+// not overridden and the field of P with the same name is used instead, so
+// the fields whose zero value is valid (AckNone, NoCompression and no linger)
+// are pointers.
+type TopicConfig struct {
+	RequiredAcks *proto.ProduceAckType // ignored by the idempotent producer, which always uses AckAll
+	AckTimeout   time.Duration
+	Compression  *proto.CompressionCodec
+	Partitioner  NewPartitionerFunc
+	BatchSize    int            // in bytes, only for Send
+	Linger       *time.Duration // only for Send
+}
+
+// topicSettings is the settings used for a topic, resolved from its
+// TopicConfig and P.
+type topicSettings struct {
+	RequiredAcks proto.ProduceAckType
+	AckTimeout   time.Duration
+	Compression  proto.CompressionCodec
+	Partitioner  NewPartitionerFunc
+	BatchSize    int
+	Linger       time.Duration
+}
+
+type topicConfigs struct {
+	m  map[string]TopicConfig
+	mu sync.Mutex
+}
+
+// TopicConfig returns the override of the topic, which is empty if none.
+// Modify the result and pass it to SetTopicConfig to change only some of the
+// settings.
+func (p *P) TopicConfig(topic string) TopicConfig {
+	p.topicConfigs.mu.Lock()
+	defer p.topicConfigs.mu.Unlock()
+	return p.topicConfigs.m[topic]
+}
+
+// SetTopicConfig overrides the settings of the topic. It takes effect from the
+// next message sent to the topic, while the messages already buffered are sent
+// with the settings when they were added.
+func (p *P) SetTopicConfig(topic string, config TopicConfig) {
+	p.topicConfigs.mu.Lock()
+	defer p.topicConfigs.mu.Unlock()
+	if p.topicConfigs.m == nil {
+		p.topicConfigs.m = make(map[string]TopicConfig)
+	}
+	p.topicConfigs.m[topic] = config
+	p.topicPartitioner.Delete(topic)
+}
+
+// DeleteTopicConfig removes the override of the topic, so that the fields of
+// P are used again.
+func (p *P) DeleteTopicConfig(topic string) {
+	p.topicConfigs.mu.Lock()
+	defer p.topicConfigs.mu.Unlock()
+	delete(p.topicConfigs.m, topic)
+	p.topicPartitioner.Delete(topic)
+}
+
+// topicSettings resolves the settings of the topic when a message is sent, so
+// that a field not overridden follows the current value of P.
+func (p *P) topicSettings(topic string) topicSettings {
+	config := p.TopicConfig(topic)
+	s := topicSettings{
+		RequiredAcks: p.RequiredAcks,
+		AckTimeout:   p.AckTimeout,
+		Compression:  p.Compression,
+		Partitioner:  p.Partitioner,
+		BatchSize:    p.BatchSize,
+		Linger:       p.Linger,
+	}
+	if config.RequiredAcks != nil {
+		s.RequiredAcks = *config.RequiredAcks
+	}
+	if config.AckTimeout != 0 {
+		s.AckTimeout = config.AckTimeout
+	}
+	if config.Compression != nil {
+		s.Compression = *config.Compression
+	}
+	if config.Partitioner != nil {
+		s.Partitioner = config.Partitioner
+	}
+	if s.Partitioner == nil {
+		s.Partitioner = NewHashPartitioner
+	}
+	if config.BatchSize != 0 {
+		s.BatchSize = config.BatchSize
+	}
+	if config.Linger != nil {
+		s.Linger = *config.Linger
+	}
+	return s
+}
+
+// partitioner returns the partitioner of the topic, created by the
+// Partitioner of its settings on the first use.
+func (p *P) partitioner(topic string) Partitioner {
+	return p.topicPartitioner.Get(topic, p.topicSettings(topic).Partitioner)
+}
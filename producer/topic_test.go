@@ -0,0 +1,134 @@
+package producer
+
+import (
+	"testing"
+	"time"
+
+	"h12.io/kpax/proto"
+)
+
+func TestTopicConfig(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b)
+	c.setTopic("b", b)
+	p := New(c)
+	p.Linger = time.Hour
+	acks, compression, linger := proto.AckAll, proto.SnappyCompression, time.Duration(0)
+	config := p.TopicConfig("b")
+	config.RequiredAcks = &acks
+	config.Compression = &compression
+	config.Linger = &linger
+	p.SetTopicConfig("b", config)
+	if p.topicSettings("a").Linger != time.Hour || p.topicSettings("b").Linger != 0 {
+		t.Fatal("expect the default config for a and the override for b")
+	}
+
+	var res results
+	p.Send("a", nil, []byte("a"), res.add)
+	done := make(chan *Record, 1)
+	p.Send("b", nil, []byte("b"), func(r *Record) { done <- r })
+	select {
+	case r := <-done:
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("b should be sent without lingering")
+	}
+	if len(res.get()) != 0 {
+		t.Fatal("a should be lingering")
+	}
+	p.Flush()
+	if acks := b.requiredAcks(); len(acks) != 2 || acks[0] != int16(proto.AckAll) || acks[1] != int16(proto.AckLocal) {
+		t.Fatalf("unexpected acks %v", acks)
+	}
+	if n := b.compressedCount(); n != 1 {
+		t.Fatalf("expect 1 compressed message set but got %d", n)
+	}
+	if m := b.messages("b", 0); len(m) != 1 || string(m[0].Value) != "b" {
+		t.Fatalf("unexpected messages %v", m)
+	}
+
+	p.DeleteTopicConfig("b")
+	if _, err := p.Produce("b", nil, []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	if acks := b.requiredAcks(); acks[len(acks)-1] != int16(proto.AckLocal) || b.compressedCount() != 1 {
+		t.Fatal("expect the default config after the override is deleted")
+	}
+}
+
+func TestTopicConfigGroupRequests(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b)
+	c.setTopic("b", b)
+	p := New(c)
+	p.Linger = time.Hour
+	acks := proto.AckAll
+	p.SetTopicConfig("b", TopicConfig{RequiredAcks: &acks})
+	var res results
+	p.Send("a", nil, []byte("a"), res.add)
+	p.Send("b", nil, []byte("b"), res.add)
+	p.Flush()
+	for _, r := range res.get() {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+	}
+	// different acks cannot share one request
+	if n := b.requestCount(); n != 2 {
+		t.Fatalf("expect 2 requests but got %d", n)
+	}
+}
+
+func TestTopicConfigIdempotentCompression(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b)
+	p := New(c)
+	p.Idempotent = true
+	compression := proto.SnappyCompression
+	p.SetTopicConfig("a", TopicConfig{Compression: &compression})
+	if _, err := p.Produce("a", nil, []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if b.compressedCount() != 1 {
+		t.Fatal("expect a compressed record batch")
+	}
+	if m := b.messages("a", 0); len(m) != 1 || string(m[0].Value) != "v" {
+		t.Fatalf("unexpected messages %v", m)
+	}
+}
+
+func TestTopicConfigPartial(t *testing.T) {
+	t.Parallel()
+	b := newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b, b)
+	p := New(c)
+	p.Linger = time.Hour
+	acks := proto.AckNone
+	p.SetTopicConfig("a", TopicConfig{RequiredAcks: &acks})
+	// a field of P changed after the override is followed
+	p.BatchSize = 1
+	s := p.topicSettings("a")
+	if s.RequiredAcks != proto.AckNone || s.AckTimeout != p.AckTimeout || s.Partitioner == nil || s.BatchSize != 1 || s.Linger != time.Hour {
+		t.Fatalf("expect the fields not overridden from P but got %+v", s)
+	}
+	var res results
+	if err := p.Send("a", []byte("k"), []byte("v"), res.add); err != nil {
+		t.Fatal(err)
+	}
+	p.Flush()
+	if rs := res.get(); len(rs) != 1 || rs[0].Err != nil {
+		t.Fatalf("unexpected results %v", rs)
+	}
+	if acks := b.requiredAcks(); len(acks) != 1 || acks[0] != int16(proto.AckNone) {
+		t.Fatalf("unexpected acks %v", acks)
+	}
+}
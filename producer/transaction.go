@@ -0,0 +1,226 @@
+package producer
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"h12.io/kpax/model"
+	"h12.io/kpax/proto"
+)
+
+var (
+	ErrNotTransactional      = errors.New("producer is not transactional")
+	ErrNoTransaction         = errors.New("no transaction in progress")
+	ErrTransactionInProgress = errors.New("transaction already in progress")
+)
+
+// Transaction writes messages to multiple partitions and commits the offsets
+// of a consumer group atomically. The messages of an aborted transaction are
+// skipped by a consumer with ReadCommitted. A producer with TransactionalID
+// has one Transaction, and the messages of the producer should only be sent
+// in it. It requires Kafka 0.11 or later.
+type Transaction struct {
+	p          *P
+	inProgress bool
+	partitions map[topicPartition]bool // added to the transaction
+	offsets    bool                    // offsets are added to the transaction
+	err        error                   // the first failure of the messages
+	mu         sync.Mutex
+}
+
+// TxnOffset is the offset of a consumed partition to be committed in a
+// transaction, which should be the offset of the next message to consume.
+type TxnOffset struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+}
+
+// Transaction returns the transaction of a producer with TransactionalID.
+func (p *P) Transaction() *Transaction {
+	return p.txn
+}
+
+// Begin starts a transaction. The first Begin obtains the producer ID, which
+// fences off any previous producer with the same TransactionalID.
+func (t *Transaction) Begin() error {
+	if t.p.TransactionalID == "" {
+		return ErrNotTransactional
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.inProgress {
+		return ErrTransactionInProgress
+	}
+	if err := t.p.acc.initProducerID(nil); err != nil {
+		return err
+	}
+	t.inProgress = true
+	t.partitions = make(map[topicPartition]bool)
+	t.offsets = false
+	t.err = nil
+	return nil
+}
+
+// Send sends a message asynchronously in the transaction like P.Send. The
+// partition is added to the transaction before the first message is sent to
+// it.
+func (t *Transaction) Send(topic string, key, value []byte, callback func(*Record)) error {
+	partitions, err := t.p.Cluster.Partitions(topic)
+	if err != nil {
+		return err
+	}
+	partition, err := t.p.partitioner(topic).Partition(key, partitions)
+	if err != nil {
+		return err
+	}
+	return t.SendWithPartition(topic, partition, key, value, callback)
+}
+
+// SendWithPartition is the same as Send except that the partition is given.
+func (t *Transaction) SendWithPartition(topic string, partition int32, key, value []byte, callback func(*Record)) error {
+	if err := t.p.checkValueSize(topic, key, value); err != nil {
+		return err
+	}
+	if err := t.addPartition(topicPartition{topic, partition}); err != nil {
+		return err
+	}
+	return t.p.SendWithPartition(topic, partition, key, value, func(r *Record) {
+		if r.Err != nil {
+			t.mu.Lock()
+			if t.err == nil {
+				t.err = r.Err
+			}
+			t.mu.Unlock()
+		}
+		if callback != nil {
+			callback(r)
+		}
+	})
+}
+
+func (t *Transaction) addPartition(tp topicPartition) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.inProgress {
+		return ErrNoTransaction
+	}
+	if t.partitions[tp] {
+		return nil
+	}
+	pid := t.p.acc.producerID()
+	err := t.p.doTxn(func(b model.Broker) error {
+		return pid.DoAddPartitions(b, []proto.PartitionInTopic{
+			{TopicName: tp.topic, Partitions: []int32{tp.partition}},
+		})
+	})
+	if err != nil {
+		return err
+	}
+	t.partitions[tp] = true
+	return nil
+}
+
+// SendOffsets commits the offsets of the consumer group when the transaction
+// is committed, so that consuming, processing and producing are done exactly
+// once.
+func (t *Transaction) SendOffsets(group string, offsets []TxnOffset) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.inProgress {
+		return ErrNoTransaction
+	}
+	pid := t.p.acc.producerID()
+	if err := t.p.doTxn(func(b model.Broker) error { return pid.DoAddOffsets(b, group) }); err != nil {
+		return err
+	}
+	t.offsets = true
+	var topics []proto.TxnOffsetInTopic
+	topicIndex := make(map[string]int)
+	for _, o := range offsets {
+		i, ok := topicIndex[o.Topic]
+		if !ok {
+			i = len(topics)
+			topicIndex[o.Topic] = i
+			topics = append(topics, proto.TxnOffsetInTopic{TopicName: o.Topic})
+		}
+		topics[i].TxnOffsetInPartitions = append(topics[i].TxnOffsetInPartitions, proto.TxnOffsetInPartition{
+			Partition: o.Partition,
+			Offset:    o.Offset,
+		})
+	}
+	return t.p.retryCoordinator(func() error {
+		coord, err := t.p.Cluster.Coordinator(group)
+		if err != nil {
+			return err
+		}
+		if err := pid.DoCommitOffsets(coord, group, topics); err != nil {
+			if proto.IsNotCoordinator(err) {
+				t.p.Cluster.CoordinatorIsDown(group)
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// Commit sends all the messages in the transaction and commits it. If any
+// message fails, the error is returned and the transaction should be aborted.
+func (t *Transaction) Commit() error {
+	return t.end(true)
+}
+
+// Abort waits for the messages sent in the transaction and aborts it.
+func (t *Transaction) Abort() error {
+	return t.end(false)
+}
+
+func (t *Transaction) end(commit bool) error {
+	t.p.Flush()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.inProgress {
+		return ErrNoTransaction
+	}
+	if commit && t.err != nil {
+		return t.err
+	}
+	if len(t.partitions) > 0 || t.offsets {
+		pid := t.p.acc.producerID()
+		if err := t.p.doTxn(func(b model.Broker) error { return pid.DoEndTxn(b, commit) }); err != nil {
+			return err
+		}
+	}
+	t.inProgress = false
+	return nil
+}
+
+// doTxn sends a request to the transaction coordinator.
+func (p *P) doTxn(do func(model.Broker) error) error {
+	return p.retryCoordinator(func() error {
+		coord, err := p.Cluster.TransactionCoordinator(p.TransactionalID)
+		if err != nil {
+			return err
+		}
+		if err := do(coord); err != nil {
+			if proto.IsNotCoordinator(err) {
+				p.Cluster.TransactionCoordinatorIsDown(p.TransactionalID)
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// retryCoordinator retries a request to a coordinator on retriable errors
+// with backoff.
+func (p *P) retryCoordinator(do func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := do()
+		if err == nil || !retriable(err) || attempt >= p.Retries {
+			return err
+		}
+		time.Sleep(p.backoff(attempt))
+	}
+}
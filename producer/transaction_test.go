@@ -0,0 +1,126 @@
+package producer
+
+import (
+	"testing"
+	"time"
+
+	"h12.io/kpax/proto"
+)
+
+func newTransactionalProducer(c *fakeCluster) *P {
+	p := New(c)
+	p.TransactionalID = "txn"
+	p.Linger = time.Hour
+	p.RetryBackoff = time.Millisecond
+	return p
+}
+
+func TestTransactionCommit(t *testing.T) {
+	t.Parallel()
+	b0, b1, coord := newFakeBroker(), newFakeBroker(), newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b0, b1)
+	c.setCoordinator(coord)
+	p := newTransactionalProducer(c)
+	txn := p.Transaction()
+	if err := txn.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	for _, partition := range []int32{0, 1, 0} {
+		if err := txn.SendWithPartition("a", partition, nil, []byte("v"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := txn.SendOffsets("group", []TxnOffset{{Topic: "in", Partition: 2, Offset: 10}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	st := coord.txnState()
+	if st.transactionalID != "txn" {
+		t.Fatalf("expect transactional ID txn but got %q", st.transactionalID)
+	}
+	if len(st.partitions) != 2 {
+		t.Fatalf("expect each partition added once but got %v", st.partitions)
+	}
+	if len(st.groups) != 1 || st.groups[0] != "group" {
+		t.Fatalf("expect the group added but got %v", st.groups)
+	}
+	if len(st.offsets) != 1 || st.offsets[0].TxnOffsetInPartitions[0].Offset != 10 {
+		t.Fatalf("expect offset 10 committed but got %v", st.offsets)
+	}
+	if len(st.ends) != 1 || !st.ends[0] {
+		t.Fatalf("expect one commit but got %v", st.ends)
+	}
+	if n := b0.txnState().batches + b1.txnState().batches; n != 2 {
+		t.Fatalf("expect 2 transactional batches but got %d", n)
+	}
+	if len(b0.messages("a", 0)) != 2 || len(b1.messages("a", 1)) != 1 {
+		t.Fatal("messages are not produced")
+	}
+}
+
+func TestTransactionAbortOnFailure(t *testing.T) {
+	t.Parallel()
+	b, coord := newFakeBroker(), newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b)
+	c.setCoordinator(coord)
+	p := newTransactionalProducer(c)
+	txn := p.Transaction()
+	if err := txn.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	b.setError("a", 0, proto.ErrMessageSizeTooLarge)
+	if err := txn.Send("a", nil, []byte("v"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(); err != proto.ErrMessageSizeTooLarge {
+		t.Fatalf("expect commit to fail but got %v", err)
+	}
+	if err := txn.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if ends := coord.txnState().ends; len(ends) != 1 || ends[0] {
+		t.Fatalf("expect one abort but got %v", ends)
+	}
+	// the next transaction reuses the producer ID
+	if err := txn.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	if n := coord.initCount(); n != 1 {
+		t.Fatalf("expect one producer ID but got %d", n)
+	}
+}
+
+func TestTransactionState(t *testing.T) {
+	t.Parallel()
+	b, coord := newFakeBroker(), newFakeBroker()
+	c := newFakeCluster()
+	c.setTopic("a", b)
+	c.setCoordinator(coord)
+	if err := New(c).Transaction().Begin(); err != ErrNotTransactional {
+		t.Fatalf("expect ErrNotTransactional but got %v", err)
+	}
+	txn := newTransactionalProducer(c).Transaction()
+	if err := txn.Send("a", nil, []byte("v"), nil); err != ErrNoTransaction {
+		t.Fatalf("expect ErrNoTransaction but got %v", err)
+	}
+	if err := txn.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Begin(); err != ErrTransactionInProgress {
+		t.Fatalf("expect ErrTransactionInProgress but got %v", err)
+	}
+	// nothing to end on the coordinator
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if ends := coord.txnState().ends; len(ends) != 0 {
+		t.Fatalf("expect no EndTxn but got %v", ends)
+	}
+	if err := txn.Commit(); err != ErrNoTransaction {
+		t.Fatalf("expect ErrNoTransaction but got %v", err)
+	}
+}
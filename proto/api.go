@@ -52,6 +52,33 @@ func (m Metadata) Fetch(b model.Broker) (*TopicMetadataResponse, error) {
 	return &resp, nil
 }
 
+// ClusterMetadata fetches the metadata of the given topics, or of all topics
+// when empty. Errors of individual topics and partitions are left in the
+// response for the caller to inspect.
+type ClusterMetadata []string
+
+func (m ClusterMetadata) Fetch(b model.Broker) (*TopicMetadataResponse, error) {
+	req := TopicMetadataRequest(m)
+	resp := TopicMetadataResponse{}
+	if err := (client{clientID, b}).Do(&req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ControllerMetadata fetches the live brokers and the controller ID without
+// any topic metadata. It requires Kafka 0.10 or later.
+type ControllerMetadata struct{}
+
+func (ControllerMetadata) Fetch(b model.Broker) (*TopicMetadataResponseV1, error) {
+	req := TopicMetadataRequestV1{}
+	resp := TopicMetadataResponseV1{}
+	if err := (client{clientID, b}).Do(&req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 type GroupCoordinator string
 
 func (group GroupCoordinator) Fetch(b model.Broker) (*Broker, error) {
@@ -66,12 +93,29 @@ func (group GroupCoordinator) Fetch(b model.Broker) (*Broker, error) {
 	return &resp.Broker, nil
 }
 
+// ListGroups lists the groups managed by a broker. It is also a cheap request
+// to check if a broker is alive.
+type ListGroups struct{}
+
+func (ListGroups) Fetch(b model.Broker) (Groups, error) {
+	req := ListGroupsRequest{}
+	resp := ListGroupsResponse{}
+	if err := (client{clientID, b}).Do(&req, &resp); err != nil {
+		return nil, err
+	}
+	if resp.HasError() {
+		return nil, resp.ErrorCode
+	}
+	return resp.Groups, nil
+}
+
 type Payload struct {
 	Topic        string
 	Partition    int32
 	MessageSet   MessageSet
 	RequiredAcks ProduceAckType
 	AckTimeout   time.Duration
+	Offset       int64 // set to the offset of the first message after produced, -1 for AckNone
 }
 
 func (p *Payload) Produce(c model.Cluster) error {
@@ -89,56 +133,200 @@ func (p *Payload) Produce(c model.Cluster) error {
 }
 
 func (p *Payload) DoProduce(b model.Broker) error {
+	return Payloads{p}.DoProduce(b)[0]
+}
+
+// Payloads are sent to a broker in one ProduceRequest, so all of them should
+// be led by that broker. RequiredAcks and AckTimeout of the first payload are
+// used for the request.
+type Payloads []*Payload
+
+// DoProduce returns an error for each payload, and sets the Offset of each
+// payload produced successfully.
+func (ps Payloads) DoProduce(b model.Broker) []error {
+	errs := make([]error, len(ps))
+	if len(ps) == 0 {
+		return errs
+	}
 	req := ProduceRequest{
-		RequiredAcks: int16(p.RequiredAcks),
-		Timeout:      int32(p.AckTimeout / time.Millisecond),
-		MessageSetInTopics: []MessageSetInTopic{
-			{
-				TopicName: p.Topic,
-				MessageSetInPartitions: []MessageSetInPartition{
-					{
-						Partition:  p.Partition,
-						MessageSet: p.MessageSet,
-					},
-				},
-			},
-		},
+		RequiredAcks: int16(ps[0].RequiredAcks),
+		Timeout:      int32(ps[0].AckTimeout / time.Millisecond),
 	}
-
-	if p.RequiredAcks == AckNone {
-		return (client{clientID, b}).Do(&req, nil)
+	topicIndex := make(map[string]int)
+	for _, p := range ps {
+		i, ok := topicIndex[p.Topic]
+		if !ok {
+			i = len(req.MessageSetInTopics)
+			topicIndex[p.Topic] = i
+			req.MessageSetInTopics = append(req.MessageSetInTopics, MessageSetInTopic{TopicName: p.Topic})
+		}
+		t := &req.MessageSetInTopics[i]
+		t.MessageSetInPartitions = append(t.MessageSetInPartitions, MessageSetInPartition{
+			Partition:  p.Partition,
+			MessageSet: p.MessageSet,
+		})
+	}
+	if ps[0].RequiredAcks == AckNone {
+		err := (client{clientID, b}).Do(&req, nil)
+		for i, p := range ps {
+			p.Offset = -1
+			errs[i] = err
+		}
+		return errs
 	}
 
 	resp := ProduceResponse{}
+	if err := (client{clientID, b}).Do(&req, &resp); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	for i, p := range ps {
+		errs[i] = fmt.Errorf("fail to produce to %s, %d", p.Topic, p.Partition)
+	nextTopic:
+		for j := range resp {
+			t := &resp[j]
+			if t.TopicName != p.Topic {
+				continue
+			}
+			for k := range t.OffsetInPartitions {
+				pres := &t.OffsetInPartitions[k]
+				if pres.Partition != p.Partition {
+					continue
+				}
+				if pres.HasError() {
+					errs[i] = pres.ErrorCode
+				} else {
+					p.Offset = pres.Offset
+					errs[i] = nil
+				}
+				break nextTopic
+			}
+		}
+	}
+	return errs
+}
+
+// ProducerID is the identity of an idempotent or transactional producer. It
+// requires Kafka 0.11 or later.
+type ProducerID struct {
+	TransactionalID    string
+	TransactionTimeout time.Duration
+	ID                 int64
+	Epoch              int16
+}
+
+// DoInit gets a new producer ID and epoch from a broker. Any broker works for
+// an idempotent producer.
+func (p *ProducerID) DoInit(b model.Broker) error {
+	req := InitProducerIDRequest{
+		TransactionalID:    NullableString(p.TransactionalID),
+		TransactionTimeout: int32(p.TransactionTimeout / time.Millisecond),
+	}
+	resp := InitProducerIDResponse{}
 	if err := (client{clientID, b}).Do(&req, &resp); err != nil {
 		return err
 	}
-	for i := range resp {
-		t := &resp[i]
-		if t.TopicName != p.Topic {
-			continue
+	if resp.HasError() {
+		return resp.ErrorCode
+	}
+	p.ID = resp.ProducerID
+	p.Epoch = resp.ProducerEpoch
+	return nil
+}
+
+// RecordPayload is a record batch to be produced with ProduceRequestV3, which
+// requires Kafka 0.11 or later.
+type RecordPayload struct {
+	Topic           string
+	Partition       int32
+	RecordBatch     RecordBatch
+	RequiredAcks    ProduceAckType
+	AckTimeout      time.Duration
+	TransactionalID string
+	Offset          int64 // set to the offset of the first record after produced, -1 for AckNone
+}
+
+// RecordPayloads are sent to a broker in one ProduceRequestV3 like Payloads.
+type RecordPayloads []*RecordPayload
+
+// DoProduce returns an error for each payload, and sets the Offset of each
+// payload produced successfully.
+func (ps RecordPayloads) DoProduce(b model.Broker) []error {
+	errs := make([]error, len(ps))
+	if len(ps) == 0 {
+		return errs
+	}
+	req := ProduceRequestV3{
+		TransactionalID: NullableString(ps[0].TransactionalID),
+		RequiredAcks:    int16(ps[0].RequiredAcks),
+		Timeout:         int32(ps[0].AckTimeout / time.Millisecond),
+	}
+	topicIndex := make(map[string]int)
+	for _, p := range ps {
+		i, ok := topicIndex[p.Topic]
+		if !ok {
+			i = len(req.RecordSetInTopics)
+			topicIndex[p.Topic] = i
+			req.RecordSetInTopics = append(req.RecordSetInTopics, RecordSetInTopic{TopicName: p.Topic})
 		}
-		for j := range t.OffsetInPartitions {
-			pres := &t.OffsetInPartitions[j]
-			if pres.Partition != p.Partition {
+		t := &req.RecordSetInTopics[i]
+		t.RecordSetInPartitions = append(t.RecordSetInPartitions, RecordSetInPartition{
+			Partition: p.Partition,
+			RecordSet: RecordSet{p.RecordBatch},
+		})
+	}
+	if ps[0].RequiredAcks == AckNone {
+		err := (client{clientID, b}).Do(&req, nil)
+		for i, p := range ps {
+			p.Offset = -1
+			errs[i] = err
+		}
+		return errs
+	}
+
+	resp := ProduceResponseV3{}
+	if err := (client{clientID, b}).Do(&req, &resp); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	for i, p := range ps {
+		errs[i] = fmt.Errorf("fail to produce to %s, %d", p.Topic, p.Partition)
+	nextTopic:
+		for j := range resp.OffsetInTopicV3s {
+			t := &resp.OffsetInTopicV3s[j]
+			if t.TopicName != p.Topic {
 				continue
 			}
-			if pres.HasError() {
-				return pres.ErrorCode
+			for k := range t.OffsetInPartitionV3s {
+				pres := &t.OffsetInPartitionV3s[k]
+				if pres.Partition != p.Partition {
+					continue
+				}
+				if pres.HasError() {
+					errs[i] = pres.ErrorCode
+				} else {
+					p.Offset = pres.Offset
+					errs[i] = nil
+				}
+				break nextTopic
 			}
-			return nil
 		}
 	}
-	return fmt.Errorf("fail to produce to %s, %d", p.Topic, p.Partition)
+	return errs
 }
 
 type Messages struct {
-	Topic       string
-	Partition   int32
-	Offset      int64
-	MinBytes    int
-	MaxBytes    int
-	MaxWaitTime time.Duration
+	Topic         string
+	Partition     int32
+	Offset        int64
+	MinBytes      int
+	MaxBytes      int
+	MaxWaitTime   time.Duration
+	ReadCommitted bool // skips aborted transactions, requires Kafka 0.11 or later
 }
 
 func (m *Messages) Consume(c model.Cluster) (MessageSet, error) {
@@ -157,6 +345,9 @@ func (m *Messages) Consume(c model.Cluster) (MessageSet, error) {
 }
 
 func (fr *Messages) DoConsume(c model.Broker) (messages MessageSet, err error) {
+	if fr.ReadCommitted {
+		return fr.doConsumeCommitted(c)
+	}
 	req := FetchRequest{
 		ReplicaID:   -1,
 		MaxWaitTime: int32(fr.MaxWaitTime / time.Millisecond),
@@ -0,0 +1,78 @@
+package proto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+)
+
+// ChunkHeaderSize is the size of the header before the data in the value of
+// a chunk: magic, ID, index and count.
+const ChunkHeaderSize = 4 + 16 + 4 + 4
+
+var chunkMagic = []byte("kpxc")
+
+// ChunkID is shared by the chunks of one value.
+type ChunkID [16]byte
+
+// NewChunkID returns a random ChunkID.
+func NewChunkID() (ChunkID, error) {
+	var id ChunkID
+	_, err := rand.Read(id[:])
+	return id, err
+}
+
+// Chunk is a part of a value too large for one message. The chunks of a
+// value are numbered by Index from 0 to Count-1 and written to the same
+// partition in order.
+type Chunk struct {
+	ID    ChunkID
+	Index int32
+	Count int32
+	Data  []byte
+}
+
+// SplitChunks splits the value into chunks with at most size bytes of data
+// each.
+func SplitChunks(value []byte, size int) ([]Chunk, error) {
+	id, err := NewChunkID()
+	if err != nil {
+		return nil, err
+	}
+	count := (len(value) + size - 1) / size
+	chunks := make([]Chunk, count)
+	for i := range chunks {
+		end := (i + 1) * size
+		if end > len(value) {
+			end = len(value)
+		}
+		chunks[i] = Chunk{ID: id, Index: int32(i), Count: int32(count), Data: value[i*size : end]}
+	}
+	return chunks, nil
+}
+
+// Marshal returns the value of the message that carries the chunk.
+func (c *Chunk) Marshal() []byte {
+	buf := make([]byte, ChunkHeaderSize, ChunkHeaderSize+len(c.Data))
+	copy(buf, chunkMagic)
+	copy(buf[4:], c.ID[:])
+	binary.BigEndian.PutUint32(buf[20:], uint32(c.Index))
+	binary.BigEndian.PutUint32(buf[24:], uint32(c.Count))
+	return append(buf, c.Data...)
+}
+
+// Unmarshal parses the value of a message and returns false if it is not a
+// chunk. Data refers to the value without copying.
+func (c *Chunk) Unmarshal(value []byte) bool {
+	if len(value) < ChunkHeaderSize || !bytes.Equal(value[:4], chunkMagic) {
+		return false
+	}
+	index := int32(binary.BigEndian.Uint32(value[20:]))
+	count := int32(binary.BigEndian.Uint32(value[24:]))
+	if index < 0 || count <= 0 || index >= count {
+		return false
+	}
+	copy(c.ID[:], value[4:20])
+	c.Index, c.Count, c.Data = index, count, value[ChunkHeaderSize:]
+	return true
+}
@@ -0,0 +1,38 @@
+package proto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestChunkRoundTrip(t *testing.T) {
+	t.Parallel()
+	value := bytes.Repeat([]byte("0123456789"), 10)
+	chunks, err := SplitChunks(value, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 4 {
+		t.Fatalf("expect 4 chunks but got %d", len(chunks))
+	}
+	var joined []byte
+	for i := range chunks {
+		var c Chunk
+		if !c.Unmarshal(chunks[i].Marshal()) {
+			t.Fatalf("fail to unmarshal chunk %d", i)
+		}
+		if c.ID != chunks[0].ID || c.Index != int32(i) || c.Count != 4 {
+			t.Fatalf("unexpected chunk %v", c)
+		}
+		joined = append(joined, c.Data...)
+	}
+	if !bytes.Equal(joined, value) {
+		t.Fatalf("expect %s but got %s", value, joined)
+	}
+	var c Chunk
+	for _, v := range [][]byte{nil, []byte("value"), append([]byte("kpxc"), make([]byte, 24)...)} {
+		if c.Unmarshal(v) {
+			t.Fatalf("%q should not be a chunk", v)
+		}
+	}
+}
@@ -8,6 +8,40 @@ import (
 	"h12.io/wipro"
 )
 
+// CompressionCodec is the codec in the attributes of a compressed message or
+// a record batch.
+type CompressionCodec int8
+
+const (
+	NoCompression     CompressionCodec = 0
+	SnappyCompression CompressionCodec = 2
+)
+
+// Compress wraps the message set into one message compressed with the codec.
+// Only snappy is supported, and the message set is returned as it is for any
+// other codec.
+func (ms MessageSet) Compress(codec CompressionCodec) MessageSet {
+	if codec != SnappyCompression || len(ms) == 0 {
+		return ms
+	}
+	inner := make(MessageSet, len(ms))
+	for i := range ms {
+		inner[i].Offset = int64(i)
+		inner[i].SizedMessage.CRCMessage.Message = ms[i].SizedMessage.CRCMessage.Message
+	}
+	var w wipro.Writer
+	inner.Marshal(&w)
+	return MessageSet{
+		{
+			Offset: int64(len(ms) - 1),
+			SizedMessage: SizedMessage{CRCMessage: CRCMessage{Message: Message{
+				Attributes: int8(codec),
+				Value:      encodeSnappy(w.B[4:]), // size must be excluded
+			}}},
+		},
+	}
+}
+
 func (m *Message) Compressed() bool {
 	return m.Attributes&0x03 > 0
 }
@@ -0,0 +1,30 @@
+package proto
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DeadLetter is the value of a message written to a dead-letter topic. It
+// wraps a message that failed to be produced or processed, with where it comes
+// from and why it failed.
+type DeadLetter struct {
+	Topic     string    `json:"topic"`
+	Partition int32     `json:"partition"`
+	Offset    int64     `json:"offset"` // -1 if never written
+	Key       []byte    `json:"key"`
+	Value     []byte    `json:"value"`
+	Error     string    `json:"error"`
+	Attempts  int       `json:"attempts"`
+	Time      time.Time `json:"time"` // when it failed
+}
+
+func (d *DeadLetter) Marshal() []byte {
+	// never fails for the field types above
+	buf, _ := json.Marshal(d)
+	return buf
+}
+
+func (d *DeadLetter) Unmarshal(value []byte) error {
+	return json.Unmarshal(value, d)
+}
@@ -0,0 +1,28 @@
+package proto
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDeadLetterRoundTrip(t *testing.T) {
+	t.Parallel()
+	d := DeadLetter{
+		Topic:     "a",
+		Partition: 1,
+		Offset:    -1,
+		Key:       []byte("k"),
+		Value:     []byte{0, 1, 2},
+		Error:     ErrRequestTimedOut.Error(),
+		Attempts:  3,
+		Time:      time.Unix(1500000000, 0).UTC(),
+	}
+	var res DeadLetter
+	if err := res.Unmarshal(d.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d, res) {
+		t.Fatalf("expect %v but got %v", d, res)
+	}
+}
@@ -10,11 +10,42 @@ var (
 	ErrCRCMismatch  = errors.New("proto: CRC mismatch in response")
 )
 
+// error codes introduced after spec.html
+const (
+	ErrUnsupportedVersionCode                 ErrorCode = 35
+	ErrOutOfOrderSequenceNumberCode           ErrorCode = 45
+	ErrDuplicateSequenceNumberCode            ErrorCode = 46
+	ErrInvalidProducerEpochCode               ErrorCode = 47
+	ErrInvalidTxnStateCode                    ErrorCode = 48
+	ErrInvalidProducerIDMappingCode           ErrorCode = 49
+	ErrInvalidTransactionTimeoutCode          ErrorCode = 50
+	ErrConcurrentTransactionsCode             ErrorCode = 51
+	ErrTransactionCoordinatorFencedCode       ErrorCode = 52
+	ErrTransactionalIDAuthorizationFailedCode ErrorCode = 53
+	ErrUnknownProducerIDCode                  ErrorCode = 59
+)
+
+var newErrTexts = map[ErrorCode]string{
+	35: "proto(35): the version of API is not supported",
+	45: "proto(45): the broker received an out of order sequence number",
+	46: "proto(46): the broker received a duplicate sequence number",
+	47: "proto(47): producer attempted an operation with an old epoch, either there is a newer producer with the same transactional ID, or the producer's transaction has been expired by the broker",
+	48: "proto(48): the producer attempted a transactional operation in an invalid state",
+	49: "proto(49): the producer attempted to use a producer id which is not currently assigned to its transactional id",
+	50: "proto(50): the transaction timeout is larger than the maximum value allowed by the broker",
+	51: "proto(51): the producer attempted to update a transaction while another concurrent operation on the same transaction was ongoing",
+	52: "proto(52): the transaction coordinator sending a WriteTxnMarker is no longer the current coordinator for a given producer",
+	53: "proto(53): transactional ID authorization failed",
+	59: "proto(59): the broker could not locate the producer metadata associated with the producer ID",
+}
+
 func (code ErrorCode) Error() string {
 	if code == -1 {
 		return "proto(-1): an unexpected server error"
-	} else if code >= 0 && int(code) < len(errTexts) {
+	} else if code >= 0 && int(code) < len(errTexts) && errTexts[code] != "" {
 		return errTexts[code]
+	} else if text, ok := newErrTexts[code]; ok {
+		return text
 	}
 	return fmt.Sprintf("proto: unknown error: %d", code)
 }
@@ -32,7 +63,11 @@ func IsNotLeader(err error) bool {
 	case ErrOffsetOutOfRange, ErrInvalidMessage, ErrInvalidMessageSize, ErrMessageSizeTooLarge,
 		ErrStaleControllerEpochCode, ErrOffsetMetadataTooLargeCode, ErrRecordListTooLargeCode,
 		ErrInvalidRequiredAcksCode, ErrIllegalGenerationCode, ErrInconsistentGroupProtocolCode,
-		ErrTopicAuthorizationFailedCode, ErrGroupAuthorizationFailedCode, ErrClusterAuthorizationFailedCode:
+		ErrTopicAuthorizationFailedCode, ErrGroupAuthorizationFailedCode, ErrClusterAuthorizationFailedCode,
+		ErrUnsupportedVersionCode, ErrOutOfOrderSequenceNumberCode, ErrDuplicateSequenceNumberCode,
+		ErrInvalidProducerEpochCode, ErrUnknownProducerIDCode, ErrInvalidTxnStateCode,
+		ErrInvalidProducerIDMappingCode, ErrInvalidTransactionTimeoutCode, ErrConcurrentTransactionsCode,
+		ErrTransactionCoordinatorFencedCode, ErrTransactionalIDAuthorizationFailedCode:
 		return false
 	}
 	return true
@@ -41,3 +76,21 @@ func IsNotLeader(err error) bool {
 func IsNotCoordinator(err error) bool {
 	return IsNotLeader(err)
 }
+
+// IsRetriable returns false if the error will not go away by sending the same
+// request again, e.g. a message is too large or the authorization fails.
+// Errors other than ErrorCode, e.g. network errors, are retriable.
+func IsRetriable(err error) bool {
+	code, ok := err.(ErrorCode)
+	if !ok {
+		return true
+	}
+	switch code {
+	case ErrInvalidMessage, ErrUnknownTopicOrPartition, ErrLeaderNotAvailable, ErrNotLeaderForPartition,
+		ErrRequestTimedOut, ErrBrokerNotAvailable, ErrReplicaNotAvailable, ErrGroupLoadInProgressCode,
+		ErrGroupCoordinatorNotAvailableCode, ErrNotCoordinatorForGroupCode, ErrNotEnoughReplicasCode,
+		ErrNotEnoughReplicasAfterAppendCode, ErrConcurrentTransactionsCode:
+		return true
+	}
+	return false
+}
@@ -12,3 +12,27 @@ func TestErrorEqual(t *testing.T) {
 		t.Fatal("error should be equal")
 	}
 }
+
+func TestIsRetriable(t *testing.T) {
+	t.Parallel()
+	for _, err := range []error{ErrNotLeaderForPartition, ErrLeaderNotAvailable, ErrRequestTimedOut, ErrSizeMismatch} {
+		if !IsRetriable(err) {
+			t.Fatalf("%v should be retriable", err)
+		}
+	}
+	for _, err := range []error{ErrMessageSizeTooLarge, ErrTopicAuthorizationFailedCode, ErrInvalidRequiredAcksCode} {
+		if IsRetriable(err) {
+			t.Fatalf("%v should not be retriable", err)
+		}
+	}
+}
+
+func TestNewErrorText(t *testing.T) {
+	t.Parallel()
+	if ErrOutOfOrderSequenceNumberCode.Error() != "proto(45): the broker received an out of order sequence number" {
+		t.Fatalf("unexpected error text %s", ErrOutOfOrderSequenceNumberCode.Error())
+	}
+	if ErrorCode(13).Error() != "proto: unknown error: 13" {
+		t.Fatalf("unexpected error text %s", ErrorCode(13).Error())
+	}
+}
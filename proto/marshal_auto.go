@@ -225,6 +225,78 @@ func (t *PartitionMetadata) Unmarshal(r *wipro.Reader) {
 	}
 }
 
+func (t *TopicMetadataRequestV1) Marshal(w *wipro.Writer) {
+	w.WriteInt32(int32(len((*t))))
+	for i := range *t {
+		w.WriteString((*t)[i])
+	}
+}
+
+func (t *TopicMetadataRequestV1) Unmarshal(r *wipro.Reader) {
+	(*t) = make([]string, int(r.ReadInt32()))
+	for i := range *t {
+		(*t)[i] = r.ReadString()
+	}
+}
+
+func (t *TopicMetadataResponseV1) Marshal(w *wipro.Writer) {
+	w.WriteInt32(int32(len(t.BrokerV1s)))
+	for i := range t.BrokerV1s {
+		t.BrokerV1s[i].Marshal(w)
+	}
+	w.WriteInt32(t.ControllerID)
+	w.WriteInt32(int32(len(t.TopicMetadataV1s)))
+	for i := range t.TopicMetadataV1s {
+		t.TopicMetadataV1s[i].Marshal(w)
+	}
+}
+
+func (t *TopicMetadataResponseV1) Unmarshal(r *wipro.Reader) {
+	t.BrokerV1s = make([]BrokerV1, int(r.ReadInt32()))
+	for i := range t.BrokerV1s {
+		t.BrokerV1s[i].Unmarshal(r)
+	}
+	t.ControllerID = r.ReadInt32()
+	t.TopicMetadataV1s = make([]TopicMetadataV1, int(r.ReadInt32()))
+	for i := range t.TopicMetadataV1s {
+		t.TopicMetadataV1s[i].Unmarshal(r)
+	}
+}
+
+func (t *BrokerV1) Marshal(w *wipro.Writer) {
+	w.WriteInt32(t.NodeID)
+	w.WriteString(t.Host)
+	w.WriteInt32(t.Port)
+	t.Rack.Marshal(w)
+}
+
+func (t *BrokerV1) Unmarshal(r *wipro.Reader) {
+	t.NodeID = r.ReadInt32()
+	t.Host = r.ReadString()
+	t.Port = r.ReadInt32()
+	t.Rack.Unmarshal(r)
+}
+
+func (t *TopicMetadataV1) Marshal(w *wipro.Writer) {
+	t.ErrorCode.Marshal(w)
+	w.WriteString(t.TopicName)
+	w.WriteInt8(t.IsInternal)
+	w.WriteInt32(int32(len(t.PartitionMetadatas)))
+	for i := range t.PartitionMetadatas {
+		t.PartitionMetadatas[i].Marshal(w)
+	}
+}
+
+func (t *TopicMetadataV1) Unmarshal(r *wipro.Reader) {
+	t.ErrorCode.Unmarshal(r)
+	t.TopicName = r.ReadString()
+	t.IsInternal = r.ReadInt8()
+	t.PartitionMetadatas = make([]PartitionMetadata, int(r.ReadInt32()))
+	for i := range t.PartitionMetadatas {
+		t.PartitionMetadatas[i].Unmarshal(r)
+	}
+}
+
 func (t *ProduceRequest) Marshal(w *wipro.Writer) {
 	w.WriteInt16(t.RequiredAcks)
 	w.WriteInt32(t.Timeout)
@@ -311,6 +383,296 @@ func (t *OffsetInPartition) Unmarshal(r *wipro.Reader) {
 	t.Offset = r.ReadInt64()
 }
 
+func (t *ProduceRequestV3) Marshal(w *wipro.Writer) {
+	t.TransactionalID.Marshal(w)
+	w.WriteInt16(t.RequiredAcks)
+	w.WriteInt32(t.Timeout)
+	w.WriteInt32(int32(len(t.RecordSetInTopics)))
+	for i := range t.RecordSetInTopics {
+		t.RecordSetInTopics[i].Marshal(w)
+	}
+}
+
+func (t *ProduceRequestV3) Unmarshal(r *wipro.Reader) {
+	t.TransactionalID.Unmarshal(r)
+	t.RequiredAcks = r.ReadInt16()
+	t.Timeout = r.ReadInt32()
+	t.RecordSetInTopics = make([]RecordSetInTopic, int(r.ReadInt32()))
+	for i := range t.RecordSetInTopics {
+		t.RecordSetInTopics[i].Unmarshal(r)
+	}
+}
+
+func (t *RecordSetInTopic) Marshal(w *wipro.Writer) {
+	w.WriteString(t.TopicName)
+	w.WriteInt32(int32(len(t.RecordSetInPartitions)))
+	for i := range t.RecordSetInPartitions {
+		t.RecordSetInPartitions[i].Marshal(w)
+	}
+}
+
+func (t *RecordSetInTopic) Unmarshal(r *wipro.Reader) {
+	t.TopicName = r.ReadString()
+	t.RecordSetInPartitions = make([]RecordSetInPartition, int(r.ReadInt32()))
+	for i := range t.RecordSetInPartitions {
+		t.RecordSetInPartitions[i].Unmarshal(r)
+	}
+}
+
+func (t *RecordSetInPartition) Marshal(w *wipro.Writer) {
+	w.WriteInt32(t.Partition)
+	t.RecordSet.Marshal(w)
+}
+
+func (t *RecordSetInPartition) Unmarshal(r *wipro.Reader) {
+	t.Partition = r.ReadInt32()
+	t.RecordSet.Unmarshal(r)
+}
+
+func (t *ProduceResponseV3) Marshal(w *wipro.Writer) {
+	w.WriteInt32(int32(len(t.OffsetInTopicV3s)))
+	for i := range t.OffsetInTopicV3s {
+		t.OffsetInTopicV3s[i].Marshal(w)
+	}
+	w.WriteInt32(t.ThrottleTime)
+}
+
+func (t *ProduceResponseV3) Unmarshal(r *wipro.Reader) {
+	t.OffsetInTopicV3s = make([]OffsetInTopicV3, int(r.ReadInt32()))
+	for i := range t.OffsetInTopicV3s {
+		t.OffsetInTopicV3s[i].Unmarshal(r)
+	}
+	t.ThrottleTime = r.ReadInt32()
+}
+
+func (t *OffsetInTopicV3) Marshal(w *wipro.Writer) {
+	w.WriteString(t.TopicName)
+	w.WriteInt32(int32(len(t.OffsetInPartitionV3s)))
+	for i := range t.OffsetInPartitionV3s {
+		t.OffsetInPartitionV3s[i].Marshal(w)
+	}
+}
+
+func (t *OffsetInTopicV3) Unmarshal(r *wipro.Reader) {
+	t.TopicName = r.ReadString()
+	t.OffsetInPartitionV3s = make([]OffsetInPartitionV3, int(r.ReadInt32()))
+	for i := range t.OffsetInPartitionV3s {
+		t.OffsetInPartitionV3s[i].Unmarshal(r)
+	}
+}
+
+func (t *OffsetInPartitionV3) Marshal(w *wipro.Writer) {
+	w.WriteInt32(t.Partition)
+	t.ErrorCode.Marshal(w)
+	w.WriteInt64(t.Offset)
+	w.WriteInt64(t.LogAppendTime)
+}
+
+func (t *OffsetInPartitionV3) Unmarshal(r *wipro.Reader) {
+	t.Partition = r.ReadInt32()
+	t.ErrorCode.Unmarshal(r)
+	t.Offset = r.ReadInt64()
+	t.LogAppendTime = r.ReadInt64()
+}
+
+func (t *InitProducerIDRequest) Marshal(w *wipro.Writer) {
+	t.TransactionalID.Marshal(w)
+	w.WriteInt32(t.TransactionTimeout)
+}
+
+func (t *InitProducerIDRequest) Unmarshal(r *wipro.Reader) {
+	t.TransactionalID.Unmarshal(r)
+	t.TransactionTimeout = r.ReadInt32()
+}
+
+func (t *InitProducerIDResponse) Marshal(w *wipro.Writer) {
+	w.WriteInt32(t.ThrottleTime)
+	t.ErrorCode.Marshal(w)
+	w.WriteInt64(t.ProducerID)
+	w.WriteInt16(t.ProducerEpoch)
+}
+
+func (t *InitProducerIDResponse) Unmarshal(r *wipro.Reader) {
+	t.ThrottleTime = r.ReadInt32()
+	t.ErrorCode.Unmarshal(r)
+	t.ProducerID = r.ReadInt64()
+	t.ProducerEpoch = r.ReadInt16()
+}
+
+func (t *AddPartitionsToTxnRequest) Marshal(w *wipro.Writer) {
+	w.WriteString(t.TransactionalID)
+	w.WriteInt64(t.ProducerID)
+	w.WriteInt16(t.ProducerEpoch)
+	w.WriteInt32(int32(len(t.PartitionInTopics)))
+	for i := range t.PartitionInTopics {
+		t.PartitionInTopics[i].Marshal(w)
+	}
+}
+
+func (t *AddPartitionsToTxnRequest) Unmarshal(r *wipro.Reader) {
+	t.TransactionalID = r.ReadString()
+	t.ProducerID = r.ReadInt64()
+	t.ProducerEpoch = r.ReadInt16()
+	t.PartitionInTopics = make([]PartitionInTopic, int(r.ReadInt32()))
+	for i := range t.PartitionInTopics {
+		t.PartitionInTopics[i].Unmarshal(r)
+	}
+}
+
+func (t *AddPartitionsToTxnResponse) Marshal(w *wipro.Writer) {
+	w.WriteInt32(t.ThrottleTime)
+	w.WriteInt32(int32(len(t.ErrorInTopics)))
+	for i := range t.ErrorInTopics {
+		t.ErrorInTopics[i].Marshal(w)
+	}
+}
+
+func (t *AddPartitionsToTxnResponse) Unmarshal(r *wipro.Reader) {
+	t.ThrottleTime = r.ReadInt32()
+	t.ErrorInTopics = make([]ErrorInTopic, int(r.ReadInt32()))
+	for i := range t.ErrorInTopics {
+		t.ErrorInTopics[i].Unmarshal(r)
+	}
+}
+
+func (t *AddOffsetsToTxnRequest) Marshal(w *wipro.Writer) {
+	w.WriteString(t.TransactionalID)
+	w.WriteInt64(t.ProducerID)
+	w.WriteInt16(t.ProducerEpoch)
+	w.WriteString(t.GroupID)
+}
+
+func (t *AddOffsetsToTxnRequest) Unmarshal(r *wipro.Reader) {
+	t.TransactionalID = r.ReadString()
+	t.ProducerID = r.ReadInt64()
+	t.ProducerEpoch = r.ReadInt16()
+	t.GroupID = r.ReadString()
+}
+
+func (t *AddOffsetsToTxnResponse) Marshal(w *wipro.Writer) {
+	w.WriteInt32(t.ThrottleTime)
+	t.ErrorCode.Marshal(w)
+}
+
+func (t *AddOffsetsToTxnResponse) Unmarshal(r *wipro.Reader) {
+	t.ThrottleTime = r.ReadInt32()
+	t.ErrorCode.Unmarshal(r)
+}
+
+func (t *EndTxnRequest) Marshal(w *wipro.Writer) {
+	w.WriteString(t.TransactionalID)
+	w.WriteInt64(t.ProducerID)
+	w.WriteInt16(t.ProducerEpoch)
+	w.WriteInt8(t.Committed)
+}
+
+func (t *EndTxnRequest) Unmarshal(r *wipro.Reader) {
+	t.TransactionalID = r.ReadString()
+	t.ProducerID = r.ReadInt64()
+	t.ProducerEpoch = r.ReadInt16()
+	t.Committed = r.ReadInt8()
+}
+
+func (t *EndTxnResponse) Marshal(w *wipro.Writer) {
+	w.WriteInt32(t.ThrottleTime)
+	t.ErrorCode.Marshal(w)
+}
+
+func (t *EndTxnResponse) Unmarshal(r *wipro.Reader) {
+	t.ThrottleTime = r.ReadInt32()
+	t.ErrorCode.Unmarshal(r)
+}
+
+func (t *TxnOffsetCommitRequest) Marshal(w *wipro.Writer) {
+	w.WriteString(t.TransactionalID)
+	w.WriteString(t.GroupID)
+	w.WriteInt64(t.ProducerID)
+	w.WriteInt16(t.ProducerEpoch)
+	w.WriteInt32(int32(len(t.TxnOffsetInTopics)))
+	for i := range t.TxnOffsetInTopics {
+		t.TxnOffsetInTopics[i].Marshal(w)
+	}
+}
+
+func (t *TxnOffsetCommitRequest) Unmarshal(r *wipro.Reader) {
+	t.TransactionalID = r.ReadString()
+	t.GroupID = r.ReadString()
+	t.ProducerID = r.ReadInt64()
+	t.ProducerEpoch = r.ReadInt16()
+	t.TxnOffsetInTopics = make([]TxnOffsetInTopic, int(r.ReadInt32()))
+	for i := range t.TxnOffsetInTopics {
+		t.TxnOffsetInTopics[i].Unmarshal(r)
+	}
+}
+
+func (t *TxnOffsetInTopic) Marshal(w *wipro.Writer) {
+	w.WriteString(t.TopicName)
+	w.WriteInt32(int32(len(t.TxnOffsetInPartitions)))
+	for i := range t.TxnOffsetInPartitions {
+		t.TxnOffsetInPartitions[i].Marshal(w)
+	}
+}
+
+func (t *TxnOffsetInTopic) Unmarshal(r *wipro.Reader) {
+	t.TopicName = r.ReadString()
+	t.TxnOffsetInPartitions = make([]TxnOffsetInPartition, int(r.ReadInt32()))
+	for i := range t.TxnOffsetInPartitions {
+		t.TxnOffsetInPartitions[i].Unmarshal(r)
+	}
+}
+
+func (t *TxnOffsetInPartition) Marshal(w *wipro.Writer) {
+	w.WriteInt32(t.Partition)
+	w.WriteInt64(t.Offset)
+	t.Metadata.Marshal(w)
+}
+
+func (t *TxnOffsetInPartition) Unmarshal(r *wipro.Reader) {
+	t.Partition = r.ReadInt32()
+	t.Offset = r.ReadInt64()
+	t.Metadata.Unmarshal(r)
+}
+
+func (t *TxnOffsetCommitResponse) Marshal(w *wipro.Writer) {
+	w.WriteInt32(t.ThrottleTime)
+	w.WriteInt32(int32(len(t.ErrorInTopics)))
+	for i := range t.ErrorInTopics {
+		t.ErrorInTopics[i].Marshal(w)
+	}
+}
+
+func (t *TxnOffsetCommitResponse) Unmarshal(r *wipro.Reader) {
+	t.ThrottleTime = r.ReadInt32()
+	t.ErrorInTopics = make([]ErrorInTopic, int(r.ReadInt32()))
+	for i := range t.ErrorInTopics {
+		t.ErrorInTopics[i].Unmarshal(r)
+	}
+}
+
+func (t *FindCoordinatorRequestV1) Marshal(w *wipro.Writer) {
+	w.WriteString(t.CoordinatorKey)
+	w.WriteInt8(t.CoordinatorType)
+}
+
+func (t *FindCoordinatorRequestV1) Unmarshal(r *wipro.Reader) {
+	t.CoordinatorKey = r.ReadString()
+	t.CoordinatorType = r.ReadInt8()
+}
+
+func (t *FindCoordinatorResponseV1) Marshal(w *wipro.Writer) {
+	w.WriteInt32(t.ThrottleTime)
+	t.ErrorCode.Marshal(w)
+	t.ErrorMessage.Marshal(w)
+	t.Broker.Marshal(w)
+}
+
+func (t *FindCoordinatorResponseV1) Unmarshal(r *wipro.Reader) {
+	t.ThrottleTime = r.ReadInt32()
+	t.ErrorCode.Unmarshal(r)
+	t.ErrorMessage.Unmarshal(r)
+	t.Broker.Unmarshal(r)
+}
+
 func (t *FetchRequest) Marshal(w *wipro.Writer) {
 	w.WriteInt32(t.ReplicaID)
 	w.WriteInt32(t.MaxWaitTime)
@@ -403,6 +765,80 @@ func (t *FetchMessageSetInPartition) Unmarshal(r *wipro.Reader) {
 	t.MessageSet.Unmarshal(r)
 }
 
+func (t *FetchRequestV4) Marshal(w *wipro.Writer) {
+	w.WriteInt32(t.ReplicaID)
+	w.WriteInt32(t.MaxWaitTime)
+	w.WriteInt32(t.MinBytes)
+	w.WriteInt32(t.MaxBytes)
+	w.WriteInt8(t.IsolationLevel)
+	w.WriteInt32(int32(len(t.FetchOffsetInTopics)))
+	for i := range t.FetchOffsetInTopics {
+		t.FetchOffsetInTopics[i].Marshal(w)
+	}
+}
+
+func (t *FetchRequestV4) Unmarshal(r *wipro.Reader) {
+	t.ReplicaID = r.ReadInt32()
+	t.MaxWaitTime = r.ReadInt32()
+	t.MinBytes = r.ReadInt32()
+	t.MaxBytes = r.ReadInt32()
+	t.IsolationLevel = r.ReadInt8()
+	t.FetchOffsetInTopics = make([]FetchOffsetInTopic, int(r.ReadInt32()))
+	for i := range t.FetchOffsetInTopics {
+		t.FetchOffsetInTopics[i].Unmarshal(r)
+	}
+}
+
+func (t *FetchResponseV4) Marshal(w *wipro.Writer) {
+	w.WriteInt32(t.ThrottleTime)
+	w.WriteInt32(int32(len(t.FetchRecordSetInTopics)))
+	for i := range t.FetchRecordSetInTopics {
+		t.FetchRecordSetInTopics[i].Marshal(w)
+	}
+}
+
+func (t *FetchResponseV4) Unmarshal(r *wipro.Reader) {
+	t.ThrottleTime = r.ReadInt32()
+	t.FetchRecordSetInTopics = make([]FetchRecordSetInTopic, int(r.ReadInt32()))
+	for i := range t.FetchRecordSetInTopics {
+		t.FetchRecordSetInTopics[i].Unmarshal(r)
+	}
+}
+
+func (t *FetchRecordSetInTopic) Marshal(w *wipro.Writer) {
+	w.WriteString(t.TopicName)
+	w.WriteInt32(int32(len(t.FetchRecordSetInPartitions)))
+	for i := range t.FetchRecordSetInPartitions {
+		t.FetchRecordSetInPartitions[i].Marshal(w)
+	}
+}
+
+func (t *FetchRecordSetInTopic) Unmarshal(r *wipro.Reader) {
+	t.TopicName = r.ReadString()
+	t.FetchRecordSetInPartitions = make([]FetchRecordSetInPartition, int(r.ReadInt32()))
+	for i := range t.FetchRecordSetInPartitions {
+		t.FetchRecordSetInPartitions[i].Unmarshal(r)
+	}
+}
+
+func (t *FetchRecordSetInPartition) Marshal(w *wipro.Writer) {
+	w.WriteInt32(t.Partition)
+	t.ErrorCode.Marshal(w)
+	w.WriteInt64(t.HighwaterMarkOffset)
+	w.WriteInt64(t.LastStableOffset)
+	t.AbortedTransactions.Marshal(w)
+	t.RecordSet.Marshal(w)
+}
+
+func (t *FetchRecordSetInPartition) Unmarshal(r *wipro.Reader) {
+	t.Partition = r.ReadInt32()
+	t.ErrorCode.Unmarshal(r)
+	t.HighwaterMarkOffset = r.ReadInt64()
+	t.LastStableOffset = r.ReadInt64()
+	t.AbortedTransactions.Unmarshal(r)
+	t.RecordSet.Unmarshal(r)
+}
+
 func (t *OffsetRequest) Marshal(w *wipro.Writer) {
 	w.WriteInt32(t.ReplicaID)
 	w.WriteInt32(int32(len(t.TimeInTopics)))
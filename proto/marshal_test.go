@@ -84,6 +84,32 @@ func TestMarshalCompressed(t *testing.T) {
 	}
 }
 
+func TestMessageSetCompress(t *testing.T) {
+	var ms MessageSet
+	for _, v := range []string{"a", "b", "c"} {
+		ms = append(ms, OffsetMessage{SizedMessage: SizedMessage{CRCMessage: CRCMessage{Message: Message{Value: []byte(v)}}}})
+	}
+	compressed := ms.Compress(SnappyCompression)
+	if len(compressed) != 1 || !compressed[0].SizedMessage.CRCMessage.Message.Compressed() {
+		t.Fatal("expect one compressed message")
+	}
+	res, err := compressed[0].SizedMessage.CRCMessage.Message.Decompress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 3 {
+		t.Fatalf("expect 3 messages but got %d", len(res))
+	}
+	for i := range res {
+		if res[i].Offset != int64(i) || string(res[i].SizedMessage.CRCMessage.Message.Value) != string(ms[i].SizedMessage.CRCMessage.Message.Value) {
+			t.Fatalf("unexpected message %d: %v", i, res[i])
+		}
+	}
+	if len(ms.Compress(NoCompression)) != 3 {
+		t.Fatal("expect the message set unchanged without compression")
+	}
+}
+
 func toJSON(v interface{}) string {
 	buf, _ := json.MarshalIndent(v, "", "    ")
 	return string(buf)
@@ -92,3 +118,21 @@ func toJSON(v interface{}) string {
 func p(v ...interface{}) {
 	fmt.Println(v...)
 }
+
+func TestBrokerV1NullRack(t *testing.T) {
+	t.Parallel()
+	var w wipro.Writer
+	w.WriteInt32(1)
+	w.WriteString("host")
+	w.WriteInt32(9092)
+	w.WriteInt16(-1) // broker.rack is not set
+	var b BrokerV1
+	r := wipro.Reader{B: w.B}
+	b.Unmarshal(&r)
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	if b.NodeID != 1 || b.Host != "host" || b.Port != 9092 || b.Rack != "" {
+		t.Fatalf("unexpected broker %+v", b)
+	}
+}
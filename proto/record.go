@@ -0,0 +1,394 @@
+package proto
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+
+	"h12.io/wipro"
+)
+
+// The record batch format (magic byte 2) is introduced in Kafka 0.11 and is
+// not described by BNF.txt because of the varint fields, so the marshaling
+// code is written by hand.
+
+var (
+	ErrVarintOverflow   = errors.New("proto: varint overflow")
+	ErrUnsupportedMagic = errors.New("proto: unsupported magic byte in record batch")
+	ErrUnsupportedCodec = errors.New("proto: unsupported compression codec in record batch")
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+const (
+	recordBatchMagic = 2
+	// the size of the fields from partition leader epoch to the record count
+	recordBatchOverhead  = 49
+	compressionCodecMask = 0x07
+
+	// TransactionalBatch is the attribute of a batch written in a
+	// transaction.
+	TransactionalBatch = 0x10
+	// ControlBatch is the attribute of a batch of control records, which
+	// marks the commit or abort of a transaction.
+	ControlBatch = 0x20
+
+	// ControlAbort and ControlCommit are the types of control records.
+	ControlAbort  = 0
+	ControlCommit = 1
+
+	// NoProducerID is the producer ID of a batch not written by an idempotent
+	// producer.
+	NoProducerID = -1
+	// NoSequence is the base sequence of a batch not written by an
+	// idempotent producer.
+	NoSequence = -1
+)
+
+// NullableString is encoded as length -1 when empty.
+type NullableString string
+
+func (t *NullableString) Marshal(w *wipro.Writer) {
+	if *t == "" {
+		w.WriteInt16(-1)
+		return
+	}
+	w.WriteString(string(*t))
+}
+
+func (t *NullableString) Unmarshal(r *wipro.Reader) {
+	n := int(r.ReadInt16())
+	if n < 0 || r.Err != nil {
+		*t = ""
+		return
+	}
+	if r.Offset+n > len(r.B) {
+		r.Err = ErrSizeMismatch
+		return
+	}
+	*t = NullableString(r.B[r.Offset : r.Offset+n])
+	r.Offset += n
+}
+
+// RecordSet is a sequence of record batches prefixed by its size in bytes.
+type RecordSet []RecordBatch
+
+// RecordBatch is a batch of records with the same producer ID, epoch and
+// consecutive sequence numbers.
+type RecordBatch struct {
+	BaseOffset           int64
+	PartitionLeaderEpoch int32
+	Attributes           int16
+	LastOffsetDelta      int32
+	FirstTimestamp       int64
+	MaxTimestamp         int64
+	ProducerID           int64
+	ProducerEpoch        int16
+	BaseSequence         int32
+	Records              []Record
+}
+
+// Transactional returns true if the batch is written in a transaction.
+func (t *RecordBatch) Transactional() bool {
+	return t.Attributes&TransactionalBatch != 0
+}
+
+// Control returns true if the batch contains a control record instead of
+// user records.
+func (t *RecordBatch) Control() bool {
+	return t.Attributes&ControlBatch != 0
+}
+
+// ControlType returns ControlAbort or ControlCommit for a control batch, or
+// -1 if the control record cannot be parsed.
+func (t *RecordBatch) ControlType() int16 {
+	if len(t.Records) == 0 || len(t.Records[0].Key) < 4 {
+		return -1
+	}
+	// the key is a version followed by the type
+	return int16(binary.BigEndian.Uint16(t.Records[0].Key[2:]))
+}
+
+type Record struct {
+	Attributes     int8
+	TimestampDelta int64
+	OffsetDelta    int32
+	Key            []byte
+	Value          []byte
+	Headers        []RecordHeader
+}
+
+type RecordHeader struct {
+	Key   string
+	Value []byte
+}
+
+// AbortedTransaction is returned by a read_committed fetch for each aborted
+// transaction that overlaps the fetched records.
+type AbortedTransaction struct {
+	ProducerID  int64
+	FirstOffset int64
+}
+
+// AbortedTransactions is encoded as length -1 when nil.
+type AbortedTransactions []AbortedTransaction
+
+func (t *AbortedTransactions) Marshal(w *wipro.Writer) {
+	if *t == nil {
+		w.WriteInt32(-1)
+		return
+	}
+	w.WriteInt32(int32(len(*t)))
+	for _, a := range *t {
+		w.WriteInt64(a.ProducerID)
+		w.WriteInt64(a.FirstOffset)
+	}
+}
+
+func (t *AbortedTransactions) Unmarshal(r *wipro.Reader) {
+	n := int(r.ReadInt32())
+	if n < 0 || r.Err != nil {
+		*t = nil
+		return
+	}
+	if n > (len(r.B)-r.Offset)/16 {
+		r.Err = ErrSizeMismatch
+		return
+	}
+	*t = make(AbortedTransactions, n)
+	for i := range *t {
+		(*t)[i].ProducerID = r.ReadInt64()
+		(*t)[i].FirstOffset = r.ReadInt64()
+	}
+}
+
+func (t *RecordSet) Marshal(w *wipro.Writer) {
+	offset := len(w.B)
+	w.WriteInt32(0)
+	start := len(w.B)
+	for i := range *t {
+		(*t)[i].Marshal(w)
+	}
+	w.SetInt32(offset, int32(len(w.B)-start))
+}
+
+// Unmarshal ignores a partial batch at the end of the record set, which can
+// be returned by a fetch request.
+func (t *RecordSet) Unmarshal(r *wipro.Reader) {
+	size := int(r.ReadInt32())
+	if r.Err != nil {
+		return
+	}
+	end := r.Offset + size
+	if end > len(r.B) {
+		r.Err = ErrSizeMismatch
+		return
+	}
+	sub := &wipro.Reader{B: r.B[:end], Offset: r.Offset}
+	for sub.Offset < end {
+		var b RecordBatch
+		b.Unmarshal(sub)
+		if sub.Err == ErrCRCMismatch || sub.Err == ErrUnsupportedMagic || sub.Err == ErrUnsupportedCodec {
+			r.Err = sub.Err
+			return
+		}
+		if sub.Err != nil {
+			break
+		}
+		*t = append(*t, b)
+	}
+	r.Offset = end
+}
+
+func (t *RecordBatch) Marshal(w *wipro.Writer) {
+	w.WriteInt64(t.BaseOffset)
+	lengthOffset := len(w.B)
+	w.WriteInt32(0)
+	lengthStart := len(w.B)
+	w.WriteInt32(t.PartitionLeaderEpoch)
+	w.WriteInt8(recordBatchMagic)
+	crcOffset := len(w.B)
+	w.WriteUint32(0)
+	crcStart := len(w.B)
+	// records are written uncompressed unless the codec is snappy
+	codec := t.Attributes & compressionCodecMask
+	if codec != int16(SnappyCompression) {
+		codec = int16(NoCompression)
+	}
+	w.WriteInt16(t.Attributes&^compressionCodecMask | codec)
+	w.WriteInt32(t.LastOffsetDelta)
+	w.WriteInt64(t.FirstTimestamp)
+	w.WriteInt64(t.MaxTimestamp)
+	w.WriteInt64(t.ProducerID)
+	w.WriteInt16(t.ProducerEpoch)
+	w.WriteInt32(t.BaseSequence)
+	w.WriteInt32(int32(len(t.Records)))
+	if codec == int16(SnappyCompression) {
+		var body wipro.Writer
+		for i := range t.Records {
+			t.Records[i].Marshal(&body)
+		}
+		w.B = append(w.B, encodeSnappy(body.B)...)
+	} else {
+		for i := range t.Records {
+			t.Records[i].Marshal(w)
+		}
+	}
+	w.SetUint32(crcOffset, crc32.Checksum(w.B[crcStart:], castagnoliTable))
+	w.SetInt32(lengthOffset, int32(len(w.B)-lengthStart))
+}
+
+func (t *RecordBatch) Unmarshal(r *wipro.Reader) {
+	t.BaseOffset = r.ReadInt64()
+	length := int(r.ReadInt32())
+	if r.Err != nil {
+		return
+	}
+	end := r.Offset + length
+	if length < recordBatchOverhead || end > len(r.B) {
+		r.Err = ErrSizeMismatch
+		return
+	}
+	t.PartitionLeaderEpoch = r.ReadInt32()
+	if magic := r.ReadInt8(); magic != recordBatchMagic {
+		r.Err = ErrUnsupportedMagic
+		return
+	}
+	crc := r.ReadUint32()
+	if crc != crc32.Checksum(r.B[r.Offset:end], castagnoliTable) {
+		r.Err = ErrCRCMismatch
+		return
+	}
+	t.Attributes = r.ReadInt16()
+	t.LastOffsetDelta = r.ReadInt32()
+	t.FirstTimestamp = r.ReadInt64()
+	t.MaxTimestamp = r.ReadInt64()
+	t.ProducerID = r.ReadInt64()
+	t.ProducerEpoch = r.ReadInt16()
+	t.BaseSequence = r.ReadInt32()
+	count := int(r.ReadInt32())
+	rr := &wipro.Reader{B: r.B[:end], Offset: r.Offset}
+	switch t.Attributes & compressionCodecMask {
+	case 0:
+	case 2:
+		bs, err := decodeSnappy(r.B[r.Offset:end])
+		if err != nil {
+			r.Err = err
+			return
+		}
+		rr = &wipro.Reader{B: bs}
+	default:
+		r.Err = ErrUnsupportedCodec
+		return
+	}
+	// a record takes at least one byte
+	if count < 0 || count > len(rr.B)-rr.Offset {
+		r.Err = ErrSizeMismatch
+		return
+	}
+	t.Records = make([]Record, count)
+	for i := range t.Records {
+		t.Records[i].Unmarshal(rr)
+	}
+	if rr.Err == nil && rr.Offset != len(rr.B) {
+		rr.Err = ErrSizeMismatch
+	}
+	if rr.Err != nil {
+		r.Err = rr.Err
+		return
+	}
+	r.Offset = end
+}
+
+func (t *Record) Marshal(w *wipro.Writer) {
+	var body wipro.Writer
+	body.WriteInt8(t.Attributes)
+	writeVarint(&body, t.TimestampDelta)
+	writeVarint(&body, int64(t.OffsetDelta))
+	writeVarintBytes(&body, t.Key)
+	writeVarintBytes(&body, t.Value)
+	writeVarint(&body, int64(len(t.Headers)))
+	for i := range t.Headers {
+		writeVarintBytes(&body, []byte(t.Headers[i].Key))
+		writeVarintBytes(&body, t.Headers[i].Value)
+	}
+	writeVarint(w, int64(len(body.B)))
+	w.B = append(w.B, body.B...)
+}
+
+func (t *Record) Unmarshal(r *wipro.Reader) {
+	length := int(readVarint(r))
+	if r.Err != nil {
+		return
+	}
+	end := r.Offset + length
+	if length < 0 || end > len(r.B) {
+		r.Err = ErrSizeMismatch
+		return
+	}
+	t.Attributes = r.ReadInt8()
+	t.TimestampDelta = readVarint(r)
+	t.OffsetDelta = int32(readVarint(r))
+	t.Key = readVarintBytes(r)
+	t.Value = readVarintBytes(r)
+	n := int(readVarint(r))
+	if r.Err != nil {
+		return
+	}
+	if n < 0 || n > end-r.Offset {
+		r.Err = ErrSizeMismatch
+		return
+	}
+	if n > 0 {
+		t.Headers = make([]RecordHeader, n)
+		for i := range t.Headers {
+			t.Headers[i].Key = string(readVarintBytes(r))
+			t.Headers[i].Value = readVarintBytes(r)
+		}
+	}
+	if r.Err == nil && r.Offset != end {
+		r.Err = ErrSizeMismatch
+	}
+}
+
+func writeVarint(w *wipro.Writer, i int64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], i)
+	w.B = append(w.B, buf[:n]...)
+}
+
+func writeVarintBytes(w *wipro.Writer, b []byte) {
+	if b == nil {
+		writeVarint(w, -1)
+		return
+	}
+	writeVarint(w, int64(len(b)))
+	w.B = append(w.B, b...)
+}
+
+func readVarint(r *wipro.Reader) int64 {
+	if r.Err != nil {
+		return 0
+	}
+	i, n := binary.Varint(r.B[r.Offset:])
+	if n <= 0 {
+		r.Err = ErrVarintOverflow
+		return 0
+	}
+	r.Offset += n
+	return i
+}
+
+func readVarintBytes(r *wipro.Reader) []byte {
+	n := int(readVarint(r))
+	if r.Err != nil || n < 0 {
+		return nil
+	}
+	if r.Offset+n > len(r.B) {
+		r.Err = ErrSizeMismatch
+		return nil
+	}
+	b := r.B[r.Offset : r.Offset+n]
+	r.Offset += n
+	return b
+}
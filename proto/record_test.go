@@ -0,0 +1,122 @@
+package proto
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"h12.io/wipro"
+)
+
+func TestRecordBatchRoundTrip(t *testing.T) {
+	t.Parallel()
+	set := RecordSet{
+		{
+			BaseOffset:      0,
+			LastOffsetDelta: 1,
+			FirstTimestamp:  1500000000000,
+			MaxTimestamp:    1500000000010,
+			ProducerID:      42,
+			ProducerEpoch:   3,
+			BaseSequence:    7,
+			Records: []Record{
+				{OffsetDelta: 0, Key: []byte("k"), Value: []byte("v")},
+				{
+					TimestampDelta: 10,
+					OffsetDelta:    1,
+					Value:          bytes.Repeat([]byte("x"), 300),
+					Headers:        []RecordHeader{{Key: "h", Value: []byte("hv")}},
+				},
+			},
+		},
+	}
+	var w wipro.Writer
+	set.Marshal(&w)
+	var res RecordSet
+	r := &wipro.Reader{B: w.B}
+	res.Unmarshal(r)
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	if r.Offset != len(w.B) {
+		t.Fatalf("expect %d bytes read but got %d", len(w.B), r.Offset)
+	}
+	if !reflect.DeepEqual(set, res) {
+		t.Fatalf("expect\n%#v\ngot\n%#v", set, res)
+	}
+
+	// corrupt the value of the last record
+	w.B[len(w.B)-10] ^= 0xff
+	res = nil
+	r = &wipro.Reader{B: w.B}
+	res.Unmarshal(r)
+	if r.Err != ErrCRCMismatch {
+		t.Fatalf("expect ErrCRCMismatch but got %v", r.Err)
+	}
+}
+
+func TestRecordSetPartialBatch(t *testing.T) {
+	t.Parallel()
+	set := RecordSet{
+		{Records: []Record{{Value: []byte("a")}}},
+		{BaseOffset: 1, Records: []Record{{Value: []byte("b")}}},
+	}
+	var w wipro.Writer
+	set.Marshal(&w)
+	// truncate the second batch like a fetch response limited by MaxBytes
+	b := append([]byte(nil), w.B[:len(w.B)-5]...)
+	b[3] -= 5
+	var res RecordSet
+	r := &wipro.Reader{B: b}
+	res.Unmarshal(r)
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	if len(res) != 1 || string(res[0].Records[0].Value) != "a" {
+		t.Fatalf("expect the first batch only but got %v", res)
+	}
+}
+
+func TestNullableString(t *testing.T) {
+	t.Parallel()
+	for _, s := range []NullableString{"", "txn"} {
+		var w wipro.Writer
+		s.Marshal(&w)
+		if s == "" && !bytes.Equal(w.B, []byte{0xff, 0xff}) {
+			t.Fatalf("empty string should be encoded as null, got %v", w.B)
+		}
+		var res NullableString
+		res.Unmarshal(&wipro.Reader{B: w.B})
+		if res != s {
+			t.Fatalf("expect %q but got %q", s, res)
+		}
+	}
+}
+
+func TestRecordBatchSnappy(t *testing.T) {
+	t.Parallel()
+	set := RecordSet{
+		{
+			LastOffsetDelta: 1,
+			Attributes:      int16(SnappyCompression),
+			Records: []Record{
+				{Value: bytes.Repeat([]byte("a"), 100)},
+				{OffsetDelta: 1, Value: bytes.Repeat([]byte("b"), 100)},
+			},
+		},
+	}
+	var w wipro.Writer
+	set.Marshal(&w)
+	if len(w.B) > 150 {
+		t.Fatalf("expect the records compressed but got %d bytes", len(w.B))
+	}
+	var res RecordSet
+	r := &wipro.Reader{B: w.B}
+	res.Unmarshal(r)
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	if !reflect.DeepEqual(set, res) {
+		t.Fatalf("expect\n%#v\ngot\n%#v", set, res)
+	}
+}
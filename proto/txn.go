@@ -0,0 +1,239 @@
+package proto
+
+import (
+	"sort"
+	"time"
+
+	"h12.io/kpax/model"
+)
+
+const (
+	coordinatorTypeTransaction = 1
+
+	isolationReadCommitted = 1
+)
+
+// TransactionCoordinator finds the coordinator of a transactional ID. It
+// requires Kafka 0.11 or later.
+type TransactionCoordinator string
+
+func (id TransactionCoordinator) Fetch(b model.Broker) (*Broker, error) {
+	req := FindCoordinatorRequestV1{
+		CoordinatorKey:  string(id),
+		CoordinatorType: coordinatorTypeTransaction,
+	}
+	resp := FindCoordinatorResponseV1{}
+	if err := (client{clientID, b}).Do(&req, &resp); err != nil {
+		return nil, err
+	}
+	if resp.HasError() {
+		return nil, resp.ErrorCode
+	}
+	return &resp.Broker, nil
+}
+
+// DoAddPartitions adds the partitions to the ongoing transaction before any
+// record is produced to them. The request must be sent to the transaction
+// coordinator.
+func (p *ProducerID) DoAddPartitions(b model.Broker, partitions []PartitionInTopic) error {
+	req := AddPartitionsToTxnRequest{
+		TransactionalID:   p.TransactionalID,
+		ProducerID:        p.ID,
+		ProducerEpoch:     p.Epoch,
+		PartitionInTopics: partitions,
+	}
+	resp := AddPartitionsToTxnResponse{}
+	if err := (client{clientID, b}).Do(&req, &resp); err != nil {
+		return err
+	}
+	return firstError(resp.ErrorInTopics)
+}
+
+// DoAddOffsets adds the offsets topic of the consumer group to the ongoing
+// transaction before DoCommitOffsets. The request must be sent to the
+// transaction coordinator.
+func (p *ProducerID) DoAddOffsets(b model.Broker, group string) error {
+	req := AddOffsetsToTxnRequest{
+		TransactionalID: p.TransactionalID,
+		ProducerID:      p.ID,
+		ProducerEpoch:   p.Epoch,
+		GroupID:         group,
+	}
+	resp := AddOffsetsToTxnResponse{}
+	if err := (client{clientID, b}).Do(&req, &resp); err != nil {
+		return err
+	}
+	if resp.HasError() {
+		return resp.ErrorCode
+	}
+	return nil
+}
+
+// DoCommitOffsets commits the offsets of the consumer group as a part of the
+// ongoing transaction. The request must be sent to the group coordinator.
+func (p *ProducerID) DoCommitOffsets(b model.Broker, group string, offsets []TxnOffsetInTopic) error {
+	req := TxnOffsetCommitRequest{
+		TransactionalID:   p.TransactionalID,
+		GroupID:           group,
+		ProducerID:        p.ID,
+		ProducerEpoch:     p.Epoch,
+		TxnOffsetInTopics: offsets,
+	}
+	resp := TxnOffsetCommitResponse{}
+	if err := (client{clientID, b}).Do(&req, &resp); err != nil {
+		return err
+	}
+	return firstError(resp.ErrorInTopics)
+}
+
+// DoEndTxn commits or aborts the ongoing transaction. The request must be
+// sent to the transaction coordinator.
+func (p *ProducerID) DoEndTxn(b model.Broker, commit bool) error {
+	req := EndTxnRequest{
+		TransactionalID: p.TransactionalID,
+		ProducerID:      p.ID,
+		ProducerEpoch:   p.Epoch,
+	}
+	if commit {
+		req.Committed = 1
+	}
+	resp := EndTxnResponse{}
+	if err := (client{clientID, b}).Do(&req, &resp); err != nil {
+		return err
+	}
+	if resp.HasError() {
+		return resp.ErrorCode
+	}
+	return nil
+}
+
+func firstError(topics []ErrorInTopic) error {
+	for i := range topics {
+		t := &topics[i]
+		for j := range t.ErrorInPartitions {
+			p := &t.ErrorInPartitions[j]
+			if p.HasError() {
+				return p.ErrorCode
+			}
+		}
+	}
+	return nil
+}
+
+// doConsumeCommitted fetches with the read_committed isolation level, which
+// returns only the records below the last stable offset, and drops the records
+// of aborted transactions and the control records. The fetch moves on if all
+// the fetched records are dropped. The topic must be in the message format of
+// Kafka 0.11.
+func (fr *Messages) doConsumeCommitted(c model.Broker) (MessageSet, error) {
+	offset := fr.Offset
+	for {
+		batches, aborted, err := fr.fetchRecords(c, offset)
+		if err != nil {
+			return nil, err
+		}
+		if len(batches) == 0 {
+			return nil, nil
+		}
+		ms := committedMessages(batches, aborted, offset)
+		if len(ms) > 0 {
+			return ms, nil
+		}
+		last := &batches[len(batches)-1]
+		offset = last.BaseOffset + int64(last.LastOffsetDelta) + 1
+	}
+}
+
+func (fr *Messages) fetchRecords(c model.Broker, offset int64) (RecordSet, AbortedTransactions, error) {
+	req := FetchRequestV4{
+		ReplicaID:      -1,
+		MaxWaitTime:    int32(fr.MaxWaitTime / time.Millisecond),
+		MinBytes:       int32(fr.MinBytes),
+		MaxBytes:       int32(fr.MaxBytes),
+		IsolationLevel: isolationReadCommitted,
+		FetchOffsetInTopics: []FetchOffsetInTopic{
+			{
+				TopicName: fr.Topic,
+				FetchOffsetInPartitions: []FetchOffsetInPartition{
+					{
+						Partition:   fr.Partition,
+						FetchOffset: offset,
+						MaxBytes:    int32(fr.MaxBytes),
+					},
+				},
+			},
+		},
+	}
+	resp := FetchResponseV4{}
+	if err := (client{clientID, c}).Do(&req, &resp); err != nil {
+		return nil, nil, err
+	}
+	for i := range resp.FetchRecordSetInTopics {
+		t := &resp.FetchRecordSetInTopics[i]
+		if t.TopicName != fr.Topic {
+			continue
+		}
+		for j := range t.FetchRecordSetInPartitions {
+			p := &t.FetchRecordSetInPartitions[j]
+			if p.Partition != fr.Partition {
+				continue
+			}
+			if p.HasError() {
+				return nil, nil, p.ErrorCode
+			}
+			return p.RecordSet, p.AbortedTransactions, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+type abortedByOffset AbortedTransactions
+
+func (s abortedByOffset) Len() int           { return len(s) }
+func (s abortedByOffset) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s abortedByOffset) Less(i, j int) bool { return s[i].FirstOffset < s[j].FirstOffset }
+
+// committedMessages converts the records from offset on to messages, skipping
+// control batches and the batches of aborted transactions. A producer is in
+// an aborted transaction from the first offset of the transaction until its
+// abort marker.
+func committedMessages(batches RecordSet, aborted AbortedTransactions, offset int64) MessageSet {
+	aborted = append(AbortedTransactions(nil), aborted...)
+	sort.Sort(abortedByOffset(aborted))
+	abortedPIDs := make(map[int64]bool)
+	var ms MessageSet
+	for i := range batches {
+		b := &batches[i]
+		lastOffset := b.BaseOffset + int64(b.LastOffsetDelta)
+		for len(aborted) > 0 && aborted[0].FirstOffset <= lastOffset {
+			abortedPIDs[aborted[0].ProducerID] = true
+			aborted = aborted[1:]
+		}
+		if b.Control() {
+			if b.ControlType() == ControlAbort {
+				delete(abortedPIDs, b.ProducerID)
+			}
+			continue
+		}
+		if b.Transactional() && abortedPIDs[b.ProducerID] {
+			continue
+		}
+		for j := range b.Records {
+			r := &b.Records[j]
+			o := b.BaseOffset + int64(r.OffsetDelta)
+			if o < offset {
+				continue
+			}
+			ms = append(ms, OffsetMessage{
+				Offset: o,
+				SizedMessage: SizedMessage{CRCMessage: CRCMessage{
+					Message: Message{
+						Key:   r.Key,
+						Value: r.Value,
+					},
+				}},
+			})
+		}
+	}
+	return ms
+}
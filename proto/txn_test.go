@@ -0,0 +1,72 @@
+package proto
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"h12.io/wipro"
+)
+
+func dataBatch(offset int64, pid int64, n int) RecordBatch {
+	b := RecordBatch{
+		BaseOffset:      offset,
+		Attributes:      TransactionalBatch,
+		LastOffsetDelta: int32(n - 1),
+		ProducerID:      pid,
+	}
+	for i := 0; i < n; i++ {
+		b.Records = append(b.Records, Record{OffsetDelta: int32(i), Value: []byte{byte(offset) + byte(i)}})
+	}
+	return b
+}
+
+func controlBatch(offset int64, pid int64, typ int16) RecordBatch {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint16(key[2:], uint16(typ))
+	return RecordBatch{
+		BaseOffset: offset,
+		Attributes: TransactionalBatch | ControlBatch,
+		ProducerID: pid,
+		Records:    []Record{{Key: key}},
+	}
+}
+
+func TestCommittedMessages(t *testing.T) {
+	t.Parallel()
+	batches := RecordSet{
+		dataBatch(0, 1, 2), // aborted
+		dataBatch(2, 2, 2), // committed
+		controlBatch(4, 1, ControlAbort),
+		controlBatch(5, 2, ControlCommit),
+		dataBatch(6, 1, 1), // a later transaction of producer 1 is committed
+		controlBatch(7, 1, ControlCommit),
+	}
+	aborted := AbortedTransactions{{ProducerID: 1, FirstOffset: 0}}
+	ms := committedMessages(batches, aborted, 3)
+	var offsets []int64
+	for _, m := range ms {
+		if m.Value[0] != byte(m.Offset) {
+			t.Fatalf("message mismatch at offset %d", m.Offset)
+		}
+		offsets = append(offsets, m.Offset)
+	}
+	if len(offsets) != 2 || offsets[0] != 3 || offsets[1] != 6 {
+		t.Fatalf("expect offsets [3 6] but got %v", offsets)
+	}
+}
+
+func TestNullAbortedTransactions(t *testing.T) {
+	t.Parallel()
+	var w wipro.Writer
+	p := FetchRecordSetInPartition{Partition: 1, RecordSet: RecordSet{dataBatch(0, 1, 1)}}
+	p.Marshal(&w)
+	var q FetchRecordSetInPartition
+	r := &wipro.Reader{B: w.B}
+	q.Unmarshal(r)
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	if q.AbortedTransactions != nil || len(q.RecordSet) != 1 {
+		t.Fatalf("unexpected partition %v", q)
+	}
+}
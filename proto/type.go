@@ -13,44 +13,66 @@ type RequestMessage interface {
 	APIVersion() int16
 }
 
-func (*ProduceRequest) APIKey() int16          { return 0 }
-func (*FetchRequest) APIKey() int16            { return 1 }
-func (*OffsetRequest) APIKey() int16           { return 2 }
-func (*TopicMetadataRequest) APIKey() int16    { return 3 }
-func (*OffsetCommitRequestV0) APIKey() int16   { return 8 }
-func (*OffsetCommitRequestV1) APIKey() int16   { return 8 }
-func (*OffsetCommitRequestV2) APIKey() int16   { return 8 }
-func (*OffsetFetchRequestV0) APIKey() int16    { return 9 }
-func (*OffsetFetchRequestV1) APIKey() int16    { return 9 }
-func (*GroupCoordinatorRequest) APIKey() int16 { return 10 }
-func (*JoinGroupRequest) APIKey() int16        { return 11 }
-func (*HeartbeatRequest) APIKey() int16        { return 12 }
-func (*LeaveGroupRequest) APIKey() int16       { return 13 }
-func (*SyncGroupRequest) APIKey() int16        { return 14 }
-func (*DescribeGroupsRequest) APIKey() int16   { return 15 }
-func (*ListGroupsRequest) APIKey() int16       { return 16 }
+func (*ProduceRequest) APIKey() int16            { return 0 }
+func (*ProduceRequestV3) APIKey() int16          { return 0 }
+func (*FetchRequest) APIKey() int16              { return 1 }
+func (*FetchRequestV4) APIKey() int16            { return 1 }
+func (*OffsetRequest) APIKey() int16             { return 2 }
+func (*TopicMetadataRequest) APIKey() int16      { return 3 }
+func (*TopicMetadataRequestV1) APIKey() int16    { return 3 }
+func (*OffsetCommitRequestV0) APIKey() int16     { return 8 }
+func (*OffsetCommitRequestV1) APIKey() int16     { return 8 }
+func (*OffsetCommitRequestV2) APIKey() int16     { return 8 }
+func (*OffsetFetchRequestV0) APIKey() int16      { return 9 }
+func (*OffsetFetchRequestV1) APIKey() int16      { return 9 }
+func (*GroupCoordinatorRequest) APIKey() int16   { return 10 }
+func (*FindCoordinatorRequestV1) APIKey() int16  { return 10 }
+func (*JoinGroupRequest) APIKey() int16          { return 11 }
+func (*HeartbeatRequest) APIKey() int16          { return 12 }
+func (*LeaveGroupRequest) APIKey() int16         { return 13 }
+func (*SyncGroupRequest) APIKey() int16          { return 14 }
+func (*DescribeGroupsRequest) APIKey() int16     { return 15 }
+func (*ListGroupsRequest) APIKey() int16         { return 16 }
+func (*InitProducerIDRequest) APIKey() int16     { return 22 }
+func (*AddPartitionsToTxnRequest) APIKey() int16 { return 24 }
+func (*AddOffsetsToTxnRequest) APIKey() int16    { return 25 }
+func (*EndTxnRequest) APIKey() int16             { return 26 }
+func (*TxnOffsetCommitRequest) APIKey() int16    { return 28 }
 
-func (*ProduceRequest) APIVersion() int16          { return 0 }
-func (*FetchRequest) APIVersion() int16            { return 0 }
-func (*OffsetRequest) APIVersion() int16           { return 0 }
-func (*TopicMetadataRequest) APIVersion() int16    { return 0 }
-func (*OffsetCommitRequestV0) APIVersion() int16   { return 0 }
-func (*OffsetCommitRequestV1) APIVersion() int16   { return 1 }
-func (*OffsetCommitRequestV2) APIVersion() int16   { return 2 }
-func (*OffsetFetchRequestV0) APIVersion() int16    { return 0 }
-func (*OffsetFetchRequestV1) APIVersion() int16    { return 1 }
-func (*GroupCoordinatorRequest) APIVersion() int16 { return 0 }
-func (*JoinGroupRequest) APIVersion() int16        { return 0 }
-func (*HeartbeatRequest) APIVersion() int16        { return 0 }
-func (*LeaveGroupRequest) APIVersion() int16       { return 0 }
-func (*SyncGroupRequest) APIVersion() int16        { return 0 }
-func (*DescribeGroupsRequest) APIVersion() int16   { return 0 }
-func (*ListGroupsRequest) APIVersion() int16       { return 0 }
+func (*ProduceRequest) APIVersion() int16            { return 0 }
+func (*ProduceRequestV3) APIVersion() int16          { return 3 }
+func (*FetchRequest) APIVersion() int16              { return 0 }
+func (*FetchRequestV4) APIVersion() int16            { return 4 }
+func (*OffsetRequest) APIVersion() int16             { return 0 }
+func (*TopicMetadataRequest) APIVersion() int16      { return 0 }
+func (*TopicMetadataRequestV1) APIVersion() int16    { return 1 }
+func (*OffsetCommitRequestV0) APIVersion() int16     { return 0 }
+func (*OffsetCommitRequestV1) APIVersion() int16     { return 1 }
+func (*OffsetCommitRequestV2) APIVersion() int16     { return 2 }
+func (*OffsetFetchRequestV0) APIVersion() int16      { return 0 }
+func (*OffsetFetchRequestV1) APIVersion() int16      { return 1 }
+func (*GroupCoordinatorRequest) APIVersion() int16   { return 0 }
+func (*FindCoordinatorRequestV1) APIVersion() int16  { return 1 }
+func (*JoinGroupRequest) APIVersion() int16          { return 0 }
+func (*HeartbeatRequest) APIVersion() int16          { return 0 }
+func (*LeaveGroupRequest) APIVersion() int16         { return 0 }
+func (*SyncGroupRequest) APIVersion() int16          { return 0 }
+func (*DescribeGroupsRequest) APIVersion() int16     { return 0 }
+func (*ListGroupsRequest) APIVersion() int16         { return 0 }
+func (*InitProducerIDRequest) APIVersion() int16     { return 0 }
+func (*AddPartitionsToTxnRequest) APIVersion() int16 { return 0 }
+func (*AddOffsetsToTxnRequest) APIVersion() int16    { return 0 }
+func (*EndTxnRequest) APIVersion() int16             { return 0 }
+func (*TxnOffsetCommitRequest) APIVersion() int16    { return 0 }
 
 func (b *Broker) Addr() string {
 	return b.Host + ":" + strconv.Itoa(int(b.Port))
 }
 
+func (b *BrokerV1) Addr() string {
+	return b.Host + ":" + strconv.Itoa(int(b.Port))
+}
+
 func (r *Response) ID() int32     { return r.CorrelationID }
 func (r *Request) ID() int32      { return r.CorrelationID }
 func (r *Request) SetID(id int32) { r.CorrelationID = id }
@@ -59,6 +59,24 @@ type PartitionMetadata struct {
 	Replicas    []int32
 	ISR         []int32
 }
+type TopicMetadataRequestV1 []string
+type TopicMetadataResponseV1 struct {
+	BrokerV1s        []BrokerV1
+	ControllerID     int32
+	TopicMetadataV1s []TopicMetadataV1
+}
+type BrokerV1 struct {
+	NodeID int32
+	Host   string
+	Port   int32
+	Rack   NullableString
+}
+type TopicMetadataV1 struct {
+	ErrorCode
+	TopicName          string
+	IsInternal         int8
+	PartitionMetadatas []PartitionMetadata
+}
 type ProduceRequest struct {
 	RequiredAcks       int16
 	Timeout            int32
@@ -82,6 +100,104 @@ type OffsetInPartition struct {
 	ErrorCode
 	Offset int64
 }
+type ProduceRequestV3 struct {
+	TransactionalID   NullableString
+	RequiredAcks      int16
+	Timeout           int32
+	RecordSetInTopics []RecordSetInTopic
+}
+type RecordSetInTopic struct {
+	TopicName             string
+	RecordSetInPartitions []RecordSetInPartition
+}
+type RecordSetInPartition struct {
+	Partition int32
+	RecordSet
+}
+type ProduceResponseV3 struct {
+	OffsetInTopicV3s []OffsetInTopicV3
+	ThrottleTime     int32
+}
+type OffsetInTopicV3 struct {
+	TopicName            string
+	OffsetInPartitionV3s []OffsetInPartitionV3
+}
+type OffsetInPartitionV3 struct {
+	Partition int32
+	ErrorCode
+	Offset        int64
+	LogAppendTime int64
+}
+type InitProducerIDRequest struct {
+	TransactionalID    NullableString
+	TransactionTimeout int32
+}
+type InitProducerIDResponse struct {
+	ThrottleTime int32
+	ErrorCode
+	ProducerID    int64
+	ProducerEpoch int16
+}
+type AddPartitionsToTxnRequest struct {
+	TransactionalID   string
+	ProducerID        int64
+	ProducerEpoch     int16
+	PartitionInTopics []PartitionInTopic
+}
+type AddPartitionsToTxnResponse struct {
+	ThrottleTime  int32
+	ErrorInTopics []ErrorInTopic
+}
+type AddOffsetsToTxnRequest struct {
+	TransactionalID string
+	ProducerID      int64
+	ProducerEpoch   int16
+	GroupID         string
+}
+type AddOffsetsToTxnResponse struct {
+	ThrottleTime int32
+	ErrorCode
+}
+type EndTxnRequest struct {
+	TransactionalID string
+	ProducerID      int64
+	ProducerEpoch   int16
+	Committed       int8
+}
+type EndTxnResponse struct {
+	ThrottleTime int32
+	ErrorCode
+}
+type TxnOffsetCommitRequest struct {
+	TransactionalID   string
+	GroupID           string
+	ProducerID        int64
+	ProducerEpoch     int16
+	TxnOffsetInTopics []TxnOffsetInTopic
+}
+type TxnOffsetInTopic struct {
+	TopicName             string
+	TxnOffsetInPartitions []TxnOffsetInPartition
+}
+type TxnOffsetInPartition struct {
+	Partition int32
+	Offset    int64
+	Metadata  NullableString
+}
+type TxnOffsetCommitResponse struct {
+	ThrottleTime  int32
+	ErrorInTopics []ErrorInTopic
+}
+type FindCoordinatorRequestV1 struct {
+	CoordinatorKey  string
+	CoordinatorType int8
+}
+type FindCoordinatorResponseV1 struct {
+	ThrottleTime int32
+	ErrorCode
+	ErrorMessage NullableString
+	Broker
+}
 type FetchRequest struct {
 	ReplicaID           int32
 	MaxWaitTime         int32
@@ -108,6 +224,30 @@ type FetchMessageSetInPartition struct {
 	HighwaterMarkOffset int64
 	MessageSet
 }
+type FetchRequestV4 struct {
+	ReplicaID           int32
+	MaxWaitTime         int32
+	MinBytes            int32
+	MaxBytes            int32
+	IsolationLevel      int8
+	FetchOffsetInTopics []FetchOffsetInTopic
+}
+type FetchResponseV4 struct {
+	ThrottleTime           int32
+	FetchRecordSetInTopics []FetchRecordSetInTopic
+}
+type FetchRecordSetInTopic struct {
+	TopicName                  string
+	FetchRecordSetInPartitions []FetchRecordSetInPartition
+}
+type FetchRecordSetInPartition struct {
+	Partition int32
+	ErrorCode
+	HighwaterMarkOffset int64
+	LastStableOffset    int64
+	AbortedTransactions
+	RecordSet
+}
 type OffsetRequest struct {
 	ReplicaID    int32
 	TimeInTopics []TimeInTopic
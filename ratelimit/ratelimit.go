@@ -0,0 +1,171 @@
+// Package ratelimit limits the messages and bytes per second of a client and
+// of each topic with token buckets.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Rate is the number of messages and bytes of keys and values per second,
+// which is also the burst allowed. Zero means unlimited.
+type Rate struct {
+	Messages float64
+	Bytes    float64
+}
+
+// Limiter limits a client, e.g. a producer or a consumer, as a whole and per
+// topic. A request larger than the burst is allowed but the following ones
+// wait until the debt is paid off. The rates can be changed at any time.
+type Limiter struct {
+	client limits
+	topics map[string]*limits
+	now    func() time.Time
+	mu     sync.Mutex
+}
+
+type limits struct {
+	messages bucket
+	bytes    bucket
+}
+
+func New(client Rate) *Limiter {
+	l := &Limiter{
+		topics: make(map[string]*limits),
+		now:    time.Now,
+	}
+	l.client.setRate(client, l.now())
+	return l
+}
+
+// Rate returns the rate of the client.
+func (l *Limiter) Rate() Rate {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.client.rate()
+}
+
+// SetRate changes the rate of the client.
+func (l *Limiter) SetRate(r Rate) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.client.setRate(r, l.now())
+}
+
+// TopicRate returns the rate of the topic, and false if it is not limited
+// separately.
+func (l *Limiter) TopicRate(topic string) (Rate, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	t, ok := l.topics[topic]
+	if !ok {
+		return Rate{}, false
+	}
+	return t.rate(), true
+}
+
+// SetTopicRate limits the topic in addition to the rate of the client.
+func (l *Limiter) SetTopicRate(topic string, r Rate) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	t, ok := l.topics[topic]
+	if !ok {
+		t = &limits{}
+		l.topics[topic] = t
+	}
+	t.setRate(r, l.now())
+}
+
+// DeleteTopicRate removes the limit of the topic.
+func (l *Limiter) DeleteTopicRate(topic string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.topics, topic)
+}
+
+// Wait takes the messages and bytes from the buckets of the client and the
+// topic, and blocks until they are available. Wait with zero messages and
+// bytes blocks until the previous debt is paid off, e.g. before a fetch whose
+// size is only known afterwards.
+func (l *Limiter) Wait(topic string, messages, bytes int) {
+	if d := l.Reserve(topic, messages, bytes); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// Reserve is the same as Wait except that it returns how long to wait instead
+// of blocking.
+func (l *Limiter) Reserve(topic string, messages, bytes int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	wait := l.client.take(messages, bytes, now)
+	if t, ok := l.topics[topic]; ok {
+		if d := t.take(messages, bytes, now); d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+func (t *limits) rate() Rate {
+	return Rate{Messages: t.messages.rate, Bytes: t.bytes.rate}
+}
+
+func (t *limits) setRate(r Rate, now time.Time) {
+	t.messages.setRate(r.Messages, now)
+	t.bytes.setRate(r.Bytes, now)
+}
+
+func (t *limits) take(messages, bytes int, now time.Time) time.Duration {
+	wait := t.messages.take(float64(messages), now)
+	if d := t.bytes.take(float64(bytes), now); d > wait {
+		wait = d
+	}
+	return wait
+}
+
+// bucket holds up to rate tokens and refills at rate per second. The tokens
+// go negative when more are taken than available.
+type bucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) setRate(rate float64, now time.Time) {
+	if b.last.IsZero() {
+		// starts full
+		b.tokens = rate
+	} else {
+		b.refill(now)
+	}
+	b.rate = rate
+	b.last = now
+	if b.tokens > rate {
+		b.tokens = rate
+	}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.rate {
+			b.tokens = b.rate
+		}
+	}
+	b.last = now
+}
+
+// take returns how long to wait until the debt is paid off.
+func (b *bucket) take(n float64, now time.Time) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	b.refill(now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
@@ -0,0 +1,54 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestLimiter(client Rate) (*Limiter, *time.Time) {
+	now := time.Now()
+	l := New(client)
+	l.now = func() time.Time { return now }
+	l.SetRate(client)
+	return l, &now
+}
+
+func TestMessageRate(t *testing.T) {
+	t.Parallel()
+	l, now := newTestLimiter(Rate{Messages: 10})
+	if d := l.Reserve("a", 10, 1000); d != 0 {
+		t.Fatalf("expect the burst allowed but wait %v", d)
+	}
+	if d := l.Reserve("a", 5, 0); d != 500*time.Millisecond {
+		t.Fatalf("expect to wait 500ms but got %v", d)
+	}
+	*now = now.Add(time.Second)
+	if d := l.Reserve("a", 0, 0); d != 0 {
+		t.Fatalf("expect the debt paid off but wait %v", d)
+	}
+}
+
+func TestTopicRate(t *testing.T) {
+	t.Parallel()
+	l, now := newTestLimiter(Rate{})
+	l.SetTopicRate("a", Rate{Bytes: 100})
+	if d := l.Reserve("b", 1000, 1000); d != 0 {
+		t.Fatalf("expect b unlimited but wait %v", d)
+	}
+	if d := l.Reserve("a", 1, 300); d != 2*time.Second {
+		t.Fatalf("expect to wait 2s but got %v", d)
+	}
+	// a lower rate takes effect on the remaining debt
+	l.SetTopicRate("a", Rate{Bytes: 50})
+	if d := l.Reserve("a", 0, 0); d != 4*time.Second {
+		t.Fatalf("expect to wait 4s but got %v", d)
+	}
+	if r, ok := l.TopicRate("a"); !ok || r.Bytes != 50 {
+		t.Fatalf("unexpected rate %v", r)
+	}
+	*now = now.Add(time.Second)
+	l.DeleteTopicRate("a")
+	if d := l.Reserve("a", 0, 1000); d != 0 {
+		t.Fatalf("expect a unlimited but wait %v", d)
+	}
+}